@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/util"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	archiveMu sync.Mutex
+	operation = regexp.MustCompile(`^\s*query\s+(\w+)`)
+)
+
+type Entry struct {
+	Query     json.RawMessage `json:"query"`
+	Response  json.RawMessage `json:"response"`
+	Cost      int64           `json:"cost"`
+	FetchedAt time.Time       `json:"fetched_at"`
+}
+
+func (e *Entry) Operation() string {
+	payload := struct {
+		Query string `json:"query"`
+	}{}
+	if err := json.Unmarshal(e.Query, &payload); err != nil {
+		return ""
+	}
+	if m := operation.FindStringSubmatch(payload.Query); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func (e *Entry) Variables(v interface{}) error {
+	payload := struct {
+		Variables interface{} `json:"variables"`
+	}{
+		Variables: v,
+	}
+	return json.Unmarshal(e.Query, &payload)
+}
+
+type Archive struct {
+	Path string
+}
+
+func (a *Archive) Write(q string, b []byte) error {
+	res := struct {
+		Data struct {
+			RateLimit rateLimit `json:"rateLimit"`
+		} `json:"data"`
+	}{}
+	_ = json.Unmarshal(b, &res)
+	entry := Entry{
+		Query:     json.RawMessage(q),
+		Response:  json.RawMessage(b),
+		Cost:      res.Data.RateLimit.Cost,
+		FetchedAt: time.Now(),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	if err := os.MkdirAll(a.Path, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(a.Path, fmt.Sprintf("responses-%s.jsonl.gz", entry.FetchedAt.UTC().Format("2006-01-02")))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (a *Archive) Each(fn func(entry *Entry) error) error {
+	names, err := filepath.Glob(filepath.Join(a.Path, "responses-*.jsonl.gz"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := each(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewArchive(path string) *Archive {
+	return &Archive{
+		Path: path,
+	}
+}
+
+func ArchivePath() string {
+	if path := viper.GetString("ARCHIVE_PATH"); path != "" {
+		return path
+	}
+	return fmt.Sprintf("%s/storage/archive", util.Root())
+}
+
+func each(name string, fn func(entry *Entry) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	decoder := json.NewDecoder(r)
+	for {
+		entry := Entry{}
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+}
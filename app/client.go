@@ -3,12 +3,14 @@ package app
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"github.com/memochou1993/gh-rankings/logger"
+	"github.com/memochou1993/gh-rankings/util"
 	"github.com/spf13/viper"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,30 +25,109 @@ func init() {
 	}
 }
 
-func Fetch(ctx context.Context, q string, v interface{}) error {
+type rateLimit struct {
+	Cost      int64  `json:"cost,omitempty"`
+	Limit     int64  `json:"limit,omitempty"`
+	Remaining int64  `json:"remaining,omitempty"`
+	ResetAt   string `json:"resetAt,omitempty"`
+}
+
+type ResponseError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Remaining  int64
+	ResetAt    time.Time
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("Request failed with status code %d", e.StatusCode)
+}
+
+func (e *ResponseError) RateLimited() bool {
+	return e.RetryAfter > 0 || (e.Remaining == 0 && !e.ResetAt.IsZero())
+}
+
+func CloseBody(closer io.ReadCloser) {
+	if err := closer.Close(); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func fetch(ctx context.Context, q string) ([]byte, error) {
+	token, err := Tokens().Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
 	body := strings.NewReader(q)
-	resp, err := post(ctx, body)
+	resp, err := post(ctx, body, token.Value)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer CloseBody(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return errors.New(fmt.Sprintf("Request failed with status code %d", resp.StatusCode))
+		err := responseError(resp)
+		if err.RateLimited() {
+			Tokens().Update(token, 0, 0, err.ResetAt)
+		}
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
-	return json.NewDecoder(resp.Body).Decode(v)
+	account(token, b)
+	return b, nil
 }
 
-func CloseBody(closer io.ReadCloser) {
-	if err := closer.Close(); err != nil {
-		log.Fatal(err.Error())
+func account(token *Token, b []byte) {
+	res := struct {
+		Data struct {
+			RateLimit rateLimit `json:"rateLimit"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return
+	}
+	r := res.Data.RateLimit
+	resetAt, err := time.Parse(time.RFC3339, r.ResetAt)
+	if err != nil {
+		return
+	}
+	Tokens().Update(token, r.Limit, r.Remaining, resetAt)
+	logger.Debug(fmt.Sprintf("Rate Limit: %s", strconv.Quote(util.ParseStruct(r, " "))))
+}
+
+func responseError(resp *http.Response) *ResponseError {
+	err := &ResponseError{
+		StatusCode: resp.StatusCode,
+		Remaining:  -1,
+	}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if seconds, e := strconv.ParseInt(s, 10, 64); e == nil {
+			err.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	if s := resp.Header.Get("X-RateLimit-Remaining"); s != "" {
+		if remaining, e := strconv.ParseInt(s, 10, 64); e == nil {
+			err.Remaining = remaining
+		}
+	}
+	if s := resp.Header.Get("X-RateLimit-Reset"); s != "" {
+		if reset, e := strconv.ParseInt(s, 10, 64); e == nil {
+			err.ResetAt = time.Unix(reset, 0)
+		}
+	}
+	if err.RetryAfter > 0 && err.ResetAt.IsZero() {
+		err.ResetAt = time.Now().Add(err.RetryAfter)
 	}
+	return err
 }
 
-func post(ctx context.Context, body io.Reader) (*http.Response, error) {
+func post(ctx context.Context, body io.Reader, token string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodPost, viper.GetString("API_URL"), body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("API_TOKEN")))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	return client.Do(req.WithContext(ctx))
 }
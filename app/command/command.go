@@ -0,0 +1,98 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/resource"
+	"github.com/memochou1993/gh-rankings/app/worker"
+	"github.com/memochou1993/gh-rankings/logger"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUsage = errors.New("invalid arguments")
+)
+
+func Fetch(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return usage("fetch <user|organization> <login>")
+	}
+	return worker.Fetch(ctx, args[0], args[1])
+}
+
+func Refresh(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return usage("refresh <user|organization>")
+	}
+	return worker.Refresh(ctx, args[0])
+}
+
+func Rank(ctx context.Context, args []string) error {
+	f := flag.NewFlagSet("rank", flag.ContinueOnError)
+	rankType := f.String("type", "", "rank type, such as user, organization or repository")
+	field := f.String("field", "", "rank field, such as followers")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if *rankType == "" {
+		if *field != "" {
+			return usage("rank [--type <type> [--field <field>]]")
+		}
+		return worker.Run(ctx, worker.CommandRank)
+	}
+	return worker.Rerank(ctx, *rankType, *field)
+}
+
+func Locate(args []string) error {
+	if len(args) == 0 {
+		return usage("locate <location>")
+	}
+	location, city := resource.Locate(strings.Join(args, " "))
+	fmt.Printf("location: %s\ncity: %s\n", location, city)
+	return nil
+}
+
+func Export(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] != "ranks" {
+		return usage("export ranks")
+	}
+	runs, err := worker.Snapshots(ctx)
+	if err != nil {
+		return err
+	}
+	rankModel := model.NewRankModel()
+	encoder := json.NewEncoder(os.Stdout)
+	for _, run := range runs {
+		count := 0
+		for _, snapshot := range run.Snapshots() {
+			n, err := rankModel.Export(ctx, snapshot, encoder)
+			if err != nil {
+				return err
+			}
+			count += n
+		}
+		logger.Success(fmt.Sprintf("Exported %d %s ranks!", count, run.Worker))
+	}
+	return nil
+}
+
+func Replay(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return usage("replay [path]")
+	}
+	path := app.ArchivePath()
+	if len(args) == 1 {
+		path = args[0]
+	}
+	return worker.Replay(ctx, path)
+}
+
+func usage(s string) error {
+	return fmt.Errorf("%w, usage: %s", ErrUsage, s)
+}
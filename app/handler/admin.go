@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/worker"
+	"github.com/spf13/viper"
+	"net/http"
+)
+
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := viper.GetString("ADMIN_TOKEN")
+		if token == "" {
+			response(w, http.StatusForbidden, Payload{Error: "Admin API is disabled"})
+			return
+		}
+		expected := []byte(fmt.Sprintf("Bearer %s", token))
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			response(w, http.StatusUnauthorized, Payload{Error: "Unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	checkpoints, err := worker.Checkpoints(r.Context())
+	if err != nil {
+		response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+		return
+	}
+
+	response(w, http.StatusOK, Payload{Data: checkpoints})
+}
+
+func ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	snapshots, err := worker.Snapshots(r.Context())
+	if err != nil {
+		response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+		return
+	}
+
+	response(w, http.StatusOK, Payload{Data: snapshots})
+}
+
+func ListRuns(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	runs, err := worker.Runs(r.Context())
+	if err != nil {
+		response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+		return
+	}
+
+	response(w, http.StatusOK, Payload{Data: runs})
+}
+
+func ListWorkers(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	response(w, http.StatusOK, Payload{Data: worker.Statuses()})
+}
+
+func CollectWorker(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	trigger(w, r, worker.CommandCollect)
+}
+
+func RankWorker(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	trigger(w, r, worker.CommandRank)
+}
+
+func RefreshWorker(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	trigger(w, r, worker.CommandRefresh)
+}
+
+func PauseWorker(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	wk, err := worker.Find(mux.Vars(r)["type"])
+	if err != nil {
+		response(w, http.StatusNotFound, Payload{Error: err.Error()})
+		return
+	}
+	wk.Pause()
+
+	response(w, http.StatusOK, Payload{Data: wk.Status()})
+}
+
+func ResumeWorker(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	wk, err := worker.Find(mux.Vars(r)["type"])
+	if err != nil {
+		response(w, http.StatusNotFound, Payload{Error: err.Error()})
+		return
+	}
+	wk.Resume()
+
+	response(w, http.StatusOK, Payload{Data: wk.Status()})
+}
+
+func trigger(w http.ResponseWriter, r *http.Request, command string) {
+	wk, err := worker.Find(mux.Vars(r)["type"])
+	if err != nil {
+		response(w, http.StatusNotFound, Payload{Error: err.Error()})
+		return
+	}
+	if err := wk.Trigger(command); err != nil {
+		status := http.StatusConflict
+		if err == worker.ErrUnsupported {
+			status = http.StatusUnprocessableEntity
+		}
+		response(w, status, Payload{Error: err.Error()})
+		return
+	}
+
+	response(w, http.StatusAccepted, Payload{Data: wk.Status()})
+}
@@ -26,7 +26,12 @@ func ListOrganizations(w http.ResponseWriter, r *http.Request) {
 	cacheKey := fmt.Sprintf("%s:%s", app.TypeOrganization, fmt.Sprint(req))
 	items, found := app.Cache.Get(cacheKey)
 	if !found {
-		items = organizationModel.List(req)
+		list, err := organizationModel.List(r.Context(), req)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
+		items = list
 		app.Cache.Set(cacheKey, &items, cache.DefaultExpiration)
 	}
 
@@ -41,8 +46,21 @@ func ShowOrganization(w http.ResponseWriter, r *http.Request) {
 	cacheKey := fmt.Sprintf("%s:%s", app.TypeOrganization, id)
 	item, found := app.Cache.Get(cacheKey)
 	if !found {
-		organization := organizationModel.FindByID(id)
+		organization, err := organizationModel.FindByLogin(r.Context(), id)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
 		if organization.ID() == "" {
+			renamed, err := organizationModel.FindByPreviousLogin(r.Context(), id)
+			if err != nil {
+				response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+				return
+			}
+			if renamed.ID() != "" {
+				http.Redirect(w, r, fmt.Sprintf("/api/organizations/%s", renamed.ID()), http.StatusMovedPermanently)
+				return
+			}
 			response(w, http.StatusNotFound, Payload{Data: nil})
 			return
 		}
@@ -5,13 +5,13 @@ import (
 	"github.com/memochou1993/gh-rankings/app"
 	"github.com/memochou1993/gh-rankings/app/handler/request"
 	"github.com/memochou1993/gh-rankings/app/model"
-	"github.com/memochou1993/gh-rankings/app/worker"
 	"github.com/patrickmn/go-cache"
 	"net/http"
 )
 
 var (
-	rankModel = model.NewRankModel()
+	rankModel      = model.NewRankModel()
+	workerRunModel = model.NewWorkerRunModel()
 )
 
 func ListRanks(w http.ResponseWriter, r *http.Request) {
@@ -23,25 +23,63 @@ func ListRanks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch req.Type {
-	case app.TypeUser:
-		req.Timestamps = append(req.Timestamps, worker.UserWorker.Timestamp)
-	case app.TypeOrganization:
-		req.Timestamps = append(req.Timestamps, worker.OrganizationWorker.Timestamp)
-	case app.TypeRepository:
-		req.Timestamps = append(req.Timestamps, worker.RepositoryWorker.Timestamp)
-	default:
-		req.Timestamps = append(req.Timestamps, worker.UserWorker.Timestamp)
-		req.Timestamps = append(req.Timestamps, worker.OrganizationWorker.Timestamp)
-		req.Timestamps = append(req.Timestamps, worker.RepositoryWorker.Timestamp)
+	runs, err := workerRunModel.Snapshots(r.Context())
+	if err != nil {
+		response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+		return
+	}
+	for _, run := range runs {
+		if req.Type == "" || req.Type == run.Worker {
+			req.Snapshots = append(req.Snapshots, run.Snapshots()...)
+		}
+	}
+	if len(req.Snapshots) == 0 {
+		response(w, http.StatusOK, Payload{Data: []model.Rank{}})
+		return
 	}
 
 	cacheKey := fmt.Sprint(req)
 	ranks, found := app.Cache.Get(cacheKey)
 	if !found {
-		ranks = rankModel.List(req)
+		list, err := rankModel.List(r.Context(), req)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
+		ranks = list
 		app.Cache.Set(cacheKey, &ranks, cache.DefaultExpiration)
 	}
 
 	response(w, http.StatusOK, Payload{Data: ranks})
 }
+
+func ListRankHistory(w http.ResponseWriter, r *http.Request) {
+	defer app.CloseBody(r.Body)
+
+	req, err := request.NewRankHistoryRequest(r)
+	if err != nil {
+		response(w, http.StatusUnprocessableEntity, Payload{Error: err.Error()})
+		return
+	}
+
+	run, err := workerRunModel.Snapshot(r.Context(), req.Type)
+	if err != nil {
+		response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+		return
+	}
+	req.Timestamp = run.Snapshot
+
+	cacheKey := fmt.Sprintf("history:%s", fmt.Sprint(req))
+	snapshots, found := app.Cache.Get(cacheKey)
+	if !found {
+		list, err := rankModel.History(r.Context(), req)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
+		snapshots = list
+		app.Cache.Set(cacheKey, &snapshots, cache.DefaultExpiration)
+	}
+
+	response(w, http.StatusOK, Payload{Data: snapshots})
+}
@@ -26,7 +26,12 @@ func ListRepositories(w http.ResponseWriter, r *http.Request) {
 	cacheKey := fmt.Sprintf("%s:%s", app.TypeRepository, fmt.Sprint(req))
 	items, found := app.Cache.Get(cacheKey)
 	if !found {
-		items = repositoryModel.List(req)
+		list, err := repositoryModel.List(r.Context(), req)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
+		items = list
 		app.Cache.Set(cacheKey, &items, cache.DefaultExpiration)
 	}
 
@@ -41,7 +46,11 @@ func ShowRepository(w http.ResponseWriter, r *http.Request) {
 	cacheKey := fmt.Sprintf("%s:%s", app.TypeRepository, id)
 	item, found := app.Cache.Get(cacheKey)
 	if !found {
-		repository := repositoryModel.FindByID(id)
+		repository, err := repositoryModel.FindByID(r.Context(), id)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
 		if repository.ID() == "" {
 			response(w, http.StatusNotFound, Payload{Data: nil})
 			return
@@ -8,20 +8,42 @@ import (
 )
 
 type Rank struct {
-	Name       string `json:"name" validate:"omitempty"`
-	Field      string `json:"field" validate:"omitempty"`
-	Type       string `json:"type" validate:"omitempty,alpha"`
-	Language   string `json:"language" validate:"omitempty"`
-	Location   string `json:"location" validate:"omitempty"`
-	Page       int64  `json:"page" validate:"omitempty,numeric"`
-	Limit      int64  `json:"limit" validate:"omitempty,numeric"`
-	Timestamps []time.Time
+	Name      string `json:"name" validate:"omitempty"`
+	Field     string `json:"field" validate:"omitempty"`
+	Type      string `json:"type" validate:"omitempty,alpha"`
+	Language  string `json:"language" validate:"omitempty"`
+	Location  string `json:"location" validate:"omitempty"`
+	Topic     string `json:"topic" validate:"omitempty"`
+	Page      int64  `json:"page" validate:"omitempty,numeric"`
+	Limit     int64  `json:"limit" validate:"omitempty,numeric"`
+	Snapshots []Snapshot
+}
+
+// Snapshot selects the ranks of a type created at the given time, limited to the given fields if any.
+type Snapshot struct {
+	Type      string
+	Fields    []string
+	CreatedAt time.Time
 }
 
 func (r *Rank) String() string {
 	return util.ParseStruct(r, ",")
 }
 
+type RankHistory struct {
+	Name      string `json:"name" validate:"required"`
+	Field     string `json:"field" validate:"required"`
+	Type      string `json:"type" validate:"required,alpha"`
+	Language  string `json:"language" validate:"omitempty"`
+	Location  string `json:"location" validate:"omitempty"`
+	Topic     string `json:"topic" validate:"omitempty"`
+	Timestamp time.Time
+}
+
+func (r *RankHistory) String() string {
+	return util.ParseStruct(r, ",")
+}
+
 func NewRankRequest(r *http.Request) (req *Rank, err error) {
 	page, err := strconv.ParseInt(r.URL.Query().Get("page"), 10, 64)
 	if err != nil || page < 1 {
@@ -37,9 +59,23 @@ func NewRankRequest(r *http.Request) (req *Rank, err error) {
 		Field:    sanitize(r.URL.Query().Get("field")),
 		Language: sanitize(r.URL.Query().Get("language")),
 		Location: sanitize(r.URL.Query().Get("location")),
+		Topic:    sanitize(r.URL.Query().Get("topic")),
 		Page:     page,
 		Limit:    limit,
 	}
 	err = validate.Struct(req)
 	return req, err
 }
+
+func NewRankHistoryRequest(r *http.Request) (req *RankHistory, err error) {
+	req = &RankHistory{
+		Name:     sanitize(r.URL.Query().Get("name")),
+		Type:     sanitize(r.URL.Query().Get("type")),
+		Field:    sanitize(r.URL.Query().Get("field")),
+		Language: sanitize(r.URL.Query().Get("language")),
+		Location: sanitize(r.URL.Query().Get("location")),
+		Topic:    sanitize(r.URL.Query().Get("topic")),
+	}
+	err = validate.Struct(req)
+	return req, err
+}
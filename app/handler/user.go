@@ -26,7 +26,12 @@ func ListUsers(w http.ResponseWriter, r *http.Request) {
 	cacheKey := fmt.Sprintf("%s:%s", app.TypeUser, fmt.Sprint(req))
 	items, found := app.Cache.Get(cacheKey)
 	if !found {
-		items = userModel.List(req)
+		list, err := userModel.List(r.Context(), req)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
+		items = list
 		app.Cache.Set(cacheKey, &items, cache.DefaultExpiration)
 	}
 
@@ -41,8 +46,21 @@ func ShowUser(w http.ResponseWriter, r *http.Request) {
 	cacheKey := fmt.Sprintf("%s:%s", app.TypeUser, id)
 	item, found := app.Cache.Get(cacheKey)
 	if !found {
-		user := userModel.FindByID(id)
+		user, err := userModel.FindByLogin(r.Context(), id)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+			return
+		}
 		if user.ID() == "" {
+			renamed, err := userModel.FindByPreviousLogin(r.Context(), id)
+			if err != nil {
+				response(w, http.StatusInternalServerError, Payload{Error: err.Error()})
+				return
+			}
+			if renamed.ID() != "" {
+				http.Redirect(w, r, fmt.Sprintf("/api/users/%s", renamed.ID()), http.StatusMovedPermanently)
+				return
+			}
 			response(w, http.StatusNotFound, Payload{Data: nil})
 			return
 		}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"context"
+	"github.com/memochou1993/gh-rankings/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	PhaseSearch    = "search"
+	PhaseResources = "resources"
+)
+
+type Checkpoint struct {
+	Worker    string    `json:"worker" bson:"_id"`
+	Phase     string    `json:"phase" bson:"phase"`
+	From      time.Time `json:"from" bson:"from"`
+	To        time.Time `json:"to" bson:"to"`
+	Cursor    string    `json:"cursor" bson:"cursor"`
+	Count     int       `json:"count" bson:"count"`
+	Owner     string    `json:"owner" bson:"owner"`
+	Owners    []string  `json:"owners" bson:"owners"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updated_at"`
+}
+
+func (c *Checkpoint) ID() string {
+	return c.Worker
+}
+
+func (c *Checkpoint) Pending() []string {
+	for i, owner := range c.Owners {
+		if owner == c.Owner {
+			return c.Owners[i:]
+		}
+	}
+	return c.Owners
+}
+
+type CheckpointModel struct {
+	*Model
+}
+
+func (c *CheckpointModel) List(ctx context.Context) (checkpoints []Checkpoint, err error) {
+	err = c.Model.List(ctx, bson.D{}, &checkpoints)
+	return
+}
+
+func (c *CheckpointModel) FindByID(ctx context.Context, id string) (checkpoint Checkpoint, err error) {
+	err = c.Model.FindByID(ctx, id, &checkpoint)
+	return
+}
+
+func (c *CheckpointModel) Store(ctx context.Context, checkpoint Checkpoint) error {
+	filter := bson.D{{"_id", checkpoint.ID()}}
+	update := bson.D{{"$set", checkpoint}}
+	return database.UpdateOne(ctx, c.Name(), filter, update, options.Update().SetUpsert(true))
+}
+
+func (c *CheckpointModel) Delete(ctx context.Context, id string) error {
+	return database.DeleteMany(ctx, c.Name(), bson.D{{"_id", id}})
+}
+
+func NewCheckpointModel() *CheckpointModel {
+	return &CheckpointModel{
+		Model: &Model{
+			name: "checkpoints",
+		},
+	}
+}
@@ -2,13 +2,20 @@ package model
 
 import (
 	"context"
+	"github.com/memochou1993/gh-rankings/app/pipeline/operator"
 	"github.com/memochou1993/gh-rankings/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
+	"time"
 )
 
+type Account struct {
+	Login          string   `bson:"login"`
+	NodeID         string   `bson:"node_id"`
+	PreviousLogins []string `bson:"previous_logins"`
+}
+
 type Interface interface {
 	Name() string
 	Collection() *mongo.Collection
@@ -26,24 +33,146 @@ func (m *Model) Collection() *mongo.Collection {
 	return database.Collection(m.name)
 }
 
-func (m *Model) List(filter bson.D, v interface{}) {
-	cursor := database.Find(m.Name(), filter)
-	if err := cursor.All(context.Background(), v); err != nil {
-		log.Fatal(err.Error())
+func (m *Model) List(ctx context.Context, filter bson.D, v interface{}) error {
+	cursor, err := database.Find(ctx, m.Name(), filter)
+	if err != nil {
+		return err
 	}
+	return cursor.All(ctx, v)
 }
 
-func (m *Model) FindByID(id string, v interface{}) {
-	res := database.FindOne(m.Name(), bson.D{{"_id", id}})
+func (m *Model) FindByID(ctx context.Context, id string, v interface{}) error {
+	res := database.FindOne(ctx, m.Name(), bson.D{{"_id", id}})
 	if err := res.Decode(v); err != nil && err != mongo.ErrNoDocuments {
-		log.Fatal(err.Error())
+		return err
 	}
+	return nil
 }
 
-func (m *Model) Last(v interface{}) {
+func (m *Model) FindByLogin(ctx context.Context, login string, v interface{}) error {
+	res := database.FindOne(ctx, m.Name(), bson.D{{"login", login}})
+	if err := res.Decode(v); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	return nil
+}
+
+func (m *Model) Last(ctx context.Context, v interface{}) error {
 	opts := options.FindOne().SetSort(bson.D{{"$natural", -1}})
-	res := database.FindOne(m.Name(), bson.D{}, opts)
+	res := database.FindOne(ctx, m.Name(), bson.D{}, opts)
+	if err := res.Decode(v); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	return nil
+}
+
+func (m *Model) FindByPreviousLogin(ctx context.Context, login string, v interface{}) error {
+	opts := options.FindOne().SetSort(bson.D{{"fetched_at", -1}})
+	res := database.FindOne(ctx, m.Name(), bson.D{{"previous_logins", login}}, opts)
 	if err := res.Decode(v); err != nil && err != mongo.ErrNoDocuments {
-		log.Fatal(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (m *Model) NodeIDs(ctx context.Context, logins []string) (map[string]string, error) {
+	nodeIDs := map[string]string{}
+	if len(logins) == 0 {
+		return nodeIDs, nil
+	}
+	opts := options.Find().SetProjection(bson.D{{"login", 1}, {"node_id", 1}})
+	cursor, err := database.Find(ctx, m.Name(), bson.D{{"login", bson.D{{"$in", logins}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer database.CloseCursor(ctx, cursor)
+	for cursor.Next(ctx) {
+		account := Account{}
+		if err := cursor.Decode(&account); err != nil {
+			return nil, err
+		}
+		nodeIDs[account.Login] = account.NodeID
+	}
+	return nodeIDs, cursor.Err()
+}
+
+func (m *Model) MarkGone(ctx context.Context, logins []string) error {
+	if len(logins) == 0 {
+		return nil
+	}
+	filter := bson.D{{"login", bson.D{{"$in", logins}}}, {"gone_at", bson.D{{"$exists", false}}}}
+	update := bson.D{{"$set", bson.D{{"gone_at", time.Now()}}}}
+	return database.UpdateMany(ctx, m.Name(), filter, update)
+}
+
+func (m *Model) renamed(ctx context.Context, accounts map[string]string) (map[string]Account, error) {
+	var nodeIDs []string
+	for nodeID := range accounts {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	renamed := map[string]Account{}
+	if len(nodeIDs) == 0 {
+		return renamed, nil
+	}
+	opts := options.Find().SetProjection(bson.D{{"login", 1}, {"node_id", 1}, {"previous_logins", 1}})
+	cursor, err := database.Find(ctx, m.Name(), bson.D{{"node_id", bson.D{{"$in", nodeIDs}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer database.CloseCursor(ctx, cursor)
+	for cursor.Next(ctx) {
+		account := Account{}
+		if err := cursor.Decode(&account); err != nil {
+			return nil, err
+		}
+		if login := accounts[account.NodeID]; login != account.Login {
+			renamed[login] = account
+		}
+	}
+	return renamed, cursor.Err()
+}
+
+// Migrate copies the login out of the _id of accounts stored before they were keyed on their node ID.
+func (m *Model) Migrate(ctx context.Context) error {
+	filter := bson.D{{"login", bson.D{{"$exists", false}}}}
+	update := mongo.Pipeline{operator.Set("login", "$_id")}
+	_, err := m.Collection().UpdateMany(ctx, filter, update)
+	return err
+}
+
+// relink points the ranks and repositories of renamed accounts at their current login.
+func (m *Model) relink(ctx context.Context, rankType string, renamed map[string]Account) error {
+	repositoryModel := NewRepositoryModel()
+	for login, previous := range renamed {
+		filter := bson.D{{"type", rankType}, {"name", previous.Login}}
+		update := bson.D{{"$set", bson.D{{"name", login}}}}
+		if err := database.UpdateMany(ctx, NewRankModel().Name(), filter, update); err != nil {
+			return err
+		}
+		if err := repositoryModel.Rekey(ctx, previous.Login, login); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// account matches an account by its node ID, or by its login when the node ID is unknown or was never stored.
+func account(login, nodeID string) bson.D {
+	if nodeID == "" {
+		return bson.D{{"login", login}}
+	}
+	return bson.D{{"$or", bson.A{
+		bson.D{{"node_id", nodeID}},
+		bson.D{{"login", login}, {"node_id", bson.D{{"$exists", false}}}},
+	}}}
+}
+
+func rename(login string, previous Account) bson.E {
+	var previousLogins []string
+	for _, previousLogin := range append(previous.PreviousLogins, previous.Login) {
+		if previousLogin != login {
+			previousLogins = append(previousLogins, previousLogin)
+		}
 	}
+	return bson.E{Key: "$addToSet", Value: bson.D{{"previous_logins", bson.D{{"$each", previousLogins}}}}}
 }
@@ -1,14 +1,15 @@
 package model
 
 import (
+	"context"
+	"github.com/memochou1993/gh-rankings/app"
 	"github.com/memochou1993/gh-rankings/app/handler/request"
 	"github.com/memochou1993/gh-rankings/app/pipeline"
 	"github.com/memochou1993/gh-rankings/app/resource"
 	"github.com/memochou1993/gh-rankings/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/net/context"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
@@ -16,11 +17,15 @@ type Organization struct {
 	ImageUrl       string       `json:"imageUrl" bson:"image_url"`
 	CreatedAt      *time.Time   `json:"createdAt" bson:"created_at"`
 	Location       string       `json:"location" bson:"location"`
-	Login          string       `json:"login" bson:"_id"`
+	Login          string       `json:"login" bson:"login"`
+	NodeID         string       `json:"id" bson:"node_id,omitempty"`
+	PreviousLogins []string     `json:"previousLogins,omitempty" bson:"previous_logins,omitempty"`
 	Name           string       `json:"name" bson:"name"`
 	Repositories   []Repository `json:"repositories,omitempty" bson:"repositories,omitempty"`
 	ParsedLocation string       `json:"parsedLocation" bson:"parsed_location"`
 	ParsedCity     string       `json:"parsedCity" bson:"parsed_city"`
+	FetchedAt      *time.Time   `json:"fetchedAt" bson:"fetched_at"`
+	GoneAt         *time.Time   `json:"goneAt,omitempty" bson:"gone_at,omitempty"`
 }
 
 func (o *Organization) ID() string {
@@ -35,46 +40,102 @@ type OrganizationModel struct {
 	*Model
 }
 
-func (o *OrganizationModel) List(req *request.Organization) (organizations []Organization) {
-	ctx := context.Background()
-
+func (o *OrganizationModel) List(ctx context.Context, req *request.Organization) (organizations []Organization, err error) {
 	p := pipeline.ListOrganizations(req)
 	if req.Q != "" {
 		p = pipeline.SearchOrganizations(req)
 	}
 
-	cursor := database.Aggregate(ctx, o.Model.Name(), p)
-	organizations = make([]Organization, req.Limit)
-	if err := cursor.All(ctx, &organizations); err != nil {
-		log.Fatal(err.Error())
+	cursor, err := database.Aggregate(ctx, o.Model.Name(), p)
+	if err != nil {
+		return
 	}
+	organizations = make([]Organization, req.Limit)
+	err = cursor.All(ctx, &organizations)
+
+	return
+}
 
+func (o *OrganizationModel) FindByLogin(ctx context.Context, login string) (organization Organization, err error) {
+	err = o.Model.FindByLogin(ctx, login, &organization)
 	return
 }
 
-func (o *OrganizationModel) FindByID(id string) (organization Organization) {
-	o.Model.FindByID(id, &organization)
+func (o *OrganizationModel) FindByPreviousLogin(ctx context.Context, login string) (organization Organization, err error) {
+	err = o.Model.FindByPreviousLogin(ctx, login, &organization)
 	return
 }
 
-func (o *OrganizationModel) Store(organizations []Organization) *mongo.BulkWriteResult {
+func (o *OrganizationModel) Store(ctx context.Context, organizations []Organization) (*mongo.BulkWriteResult, error) {
 	if len(organizations) == 0 {
-		return nil
+		return nil, nil
+	}
+	accounts := map[string]string{}
+	for _, organization := range organizations {
+		if organization.NodeID != "" {
+			accounts[organization.NodeID] = organization.ID()
+		}
+	}
+	renamed, err := o.Model.renamed(ctx, accounts)
+	if err != nil {
+		return nil, err
 	}
 	var models []mongo.WriteModel
+	fetchedAt := time.Now()
 	for _, organization := range organizations {
 		organization.parseLocation()
-		filter := bson.D{{"_id", organization.ID()}}
-		update := bson.D{{"$set", organization}}
+		if organization.FetchedAt == nil {
+			organization.FetchedAt = &fetchedAt
+		}
+		organization.PreviousLogins = nil
+		organization.GoneAt = nil
+		filter := account(organization.ID(), organization.NodeID)
+		update := bson.D{{"$set", organization}, {"$unset", bson.D{{"gone_at", ""}}}}
+		if previous, ok := renamed[organization.ID()]; ok {
+			update = append(update, rename(organization.ID(), previous))
+		}
 		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
 	}
-	return database.BulkWrite(o.Name(), models)
+	res, err := database.BulkWrite(ctx, o.Name(), models)
+	if err != nil {
+		return nil, err
+	}
+	return res, o.Model.relink(ctx, app.TypeOrganization, renamed)
 }
 
-func (o *OrganizationModel) UpdateRepositories(organization Organization, repositories []Repository) {
-	filter := bson.D{{"_id", organization.ID()}}
+func (o *OrganizationModel) UpdateRepositories(ctx context.Context, organization Organization, repositories []Repository) error {
+	filter := bson.D{{"login", organization.ID()}}
 	update := bson.D{{"$set", bson.D{{"repositories", repositories}}}}
-	database.UpdateOne(o.Name(), filter, update)
+	return database.UpdateOne(ctx, o.Name(), filter, update)
+}
+
+func (o *OrganizationModel) CreateIndexes(ctx context.Context) error {
+	return database.CreateIndexes(ctx, o.Name(), []string{"fetched_at", "login", "node_id", "previous_logins"})
+}
+
+func (o *OrganizationModel) Stale(ctx context.Context, before time.Time, limit int64) (organizations []Organization, err error) {
+	filter := bson.D{{"fetched_at", bson.D{{"$not", bson.D{{"$gte", before}}}}}}
+	opts := options.Find().SetSort(bson.D{{"fetched_at", 1}}).SetProjection(bson.D{{"login", 1}, {"node_id", 1}}).SetLimit(limit)
+	cursor, err := database.Find(ctx, o.Name(), filter, opts)
+	if err != nil {
+		return
+	}
+	defer database.CloseCursor(ctx, cursor)
+	for cursor.Next(ctx) {
+		organization := Organization{}
+		if err = cursor.Decode(&organization); err != nil {
+			return
+		}
+		organizations = append(organizations, organization)
+	}
+	err = cursor.Err()
+	return
+}
+
+func (o *OrganizationModel) Touch(ctx context.Context, logins []string) error {
+	filter := bson.D{{"login", bson.D{{"$in", logins}}}}
+	update := bson.D{{"$set", bson.D{{"fetched_at", time.Now()}}}}
+	return database.UpdateMany(ctx, o.Name(), filter, update)
 }
 
 func NewOrganizationModel() *OrganizationModel {
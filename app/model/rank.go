@@ -2,27 +2,53 @@ package model
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/memochou1993/gh-rankings/app/handler/request"
 	"github.com/memochou1993/gh-rankings/app/pipeline"
+	"github.com/memochou1993/gh-rankings/app/pipeline/operator"
 	"github.com/memochou1993/gh-rankings/database"
 	"github.com/memochou1993/gh-rankings/logger"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
 type Rank struct {
-	Name      string    `json:"name" bson:"name"`
-	ImageUrl  string    `json:"imageUrl" bson:"image_url"`
+	Name            string    `json:"name" bson:"name"`
+	ImageUrl        string    `json:"imageUrl" bson:"image_url"`
+	Rank            int       `json:"rank" bson:"rank"`
+	RankCount       int       `json:"rankCount" bson:"rank_count"`
+	ItemCount       int       `json:"itemCount" bson:"item_count"`
+	Type            string    `json:"type" bson:"type"`
+	Field           string    `json:"field" bson:"field"`
+	Language        string    `json:"language" bson:"language"`
+	Location        string    `json:"location" bson:"location"`
+	Topic           string    `json:"topic" bson:"topic,omitempty"`
+	CreatedAt       time.Time `json:"createdAt" bson:"created_at"`
+	PreviousRank    int       `json:"previousRank" bson:"previous_rank"`
+	RankChange      int       `json:"rankChange" bson:"rank_change"`
+	ItemCountChange int       `json:"itemCountChange" bson:"item_count_change"`
+	IsNew           bool      `json:"isNew" bson:"is_new"`
+}
+
+func (r *Rank) compare(previous map[string]Rank) {
+	prev, ok := previous[r.Name]
+	if !ok {
+		r.IsNew = true
+		return
+	}
+	r.PreviousRank = prev.Rank
+	r.RankChange = prev.Rank - r.Rank
+	r.ItemCountChange = r.ItemCount - prev.ItemCount
+}
+
+type RankSnapshot struct {
 	Rank      int       `json:"rank" bson:"rank"`
 	RankCount int       `json:"rankCount" bson:"rank_count"`
 	ItemCount int       `json:"itemCount" bson:"item_count"`
-	Type      string    `json:"type" bson:"type"`
-	Field     string    `json:"field" bson:"field"`
-	Language  string    `json:"language" bson:"language"`
-	Location  string    `json:"location" bson:"location"`
 	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
 }
 
@@ -30,92 +56,282 @@ type RankModel struct {
 	*Model
 }
 
-func (r *RankModel) CreateIndexes() {
-	indexes := []string{"name", "type", "field", "language", "location", "created_at"}
-	database.CreateIndexes(r.Name(), indexes)
-	logger.Success(fmt.Sprintf("Created %d indexes on %s collection!", len(indexes), r.Name()))
+func (r *RankModel) CreateIndexes(ctx context.Context) error {
+	indexes := []string{"name", "type", "field", "language", "location", "topic", "created_at"}
+	if err := database.CreateIndexes(ctx, r.Name(), indexes); err != nil {
+		return err
+	}
+	// The compound index serves the rank lists, which select a slice of a field and sort its snapshots by creation time.
+	slice := bson.D{
+		{"type", 1},
+		{"field", 1},
+		{"language", 1},
+		{"location", 1},
+		{"topic", 1},
+		{"created_at", -1},
+	}
+	if err := database.CreateCompoundIndex(ctx, r.Name(), "slice", slice); err != nil {
+		return err
+	}
+	logger.Success(fmt.Sprintf("Created %d indexes on %s collection!", len(indexes)+1, r.Name()))
+	return nil
 }
 
-func (r *RankModel) List(req *request.Rank) []Rank {
-	ctx := context.Background()
-
+func (r *RankModel) List(ctx context.Context, req *request.Rank) (ranks []Rank, err error) {
 	p := pipeline.ListRanks(req)
 	if req.Type != "" {
 		p = pipeline.SearchRanks(req)
 	}
 
-	cursor := database.Aggregate(ctx, r.Model.Name(), p)
-	ranks := make([]Rank, req.Limit)
-	if err := cursor.All(ctx, &ranks); err != nil {
-		log.Fatal(err.Error())
+	cursor, err := database.Aggregate(ctx, r.Model.Name(), p)
+	if err != nil {
+		return
 	}
+	ranks = make([]Rank, req.Limit)
+	err = cursor.All(ctx, &ranks)
 
-	return ranks
+	return
 }
 
-func (r *RankModel) Store(model Interface, p pipeline.Pipeline, createdAt time.Time) {
-	ctx := context.Background()
-	cursor := database.Aggregate(ctx, model.Name(), *p.Pipeline)
-	defer database.CloseCursor(ctx, cursor)
+func (r *RankModel) History(ctx context.Context, req *request.RankHistory) (snapshots []RankSnapshot, err error) {
+	cursor, err := database.Aggregate(ctx, r.Model.Name(), pipeline.RankHistory(req))
+	if err != nil {
+		return
+	}
+	err = cursor.All(ctx, &snapshots)
 
-	count := r.Count(model, p)
+	return
+}
 
-	var models []mongo.WriteModel
+func (r *RankModel) Store(ctx context.Context, model Interface, p pipeline.Pipeline, createdAt time.Time) error {
+	return r.store(ctx, model, p, createdAt, createdAt)
+}
+
+func (r *RankModel) store(ctx context.Context, model Interface, p pipeline.Pipeline, createdAt time.Time, before time.Time) error {
+	counts, err := r.Count(ctx, model, p)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := database.Aggregate(ctx, model.Name(), *p.Pipeline)
+	if err != nil {
+		return err
+	}
+	defer database.CloseCursor(ctx, cursor)
+
+	var ranks []Rank
+	var current pipeline.Pipeline
+	var last time.Time
 	for i := 0; cursor.Next(ctx); i++ {
 		rec := struct {
 			ID         string `bson:"_id"`
 			ImageUrl   string `bson:"image_url"`
 			TotalCount int    `bson:"total_count"`
+			Language   string `bson:"language"`
+			Location   string `bson:"location"`
 		}{}
 		if err := cursor.Decode(&rec); err != nil {
-			log.Fatal(err.Error())
+			return err
+		}
+		if s := p.Slice(rec.Language, rec.Location); i == 0 || s.Language != current.Language || s.Location != current.Location {
+			if err := r.insert(ctx, current, last, ranks); err != nil {
+				return err
+			}
+			if last, err = r.lastCreatedAt(ctx, s, before); err != nil {
+				return err
+			}
+			current, ranks, i = s, ranks[:0], 0
 		}
 
-		rank := Rank{
+		ranks = append(ranks, Rank{
 			Name:      rec.ID,
 			ImageUrl:  rec.ImageUrl,
 			Rank:      i + 1,
-			RankCount: count,
+			RankCount: counts[[2]string{current.Language, current.Location}],
 			ItemCount: rec.TotalCount,
-			Type:      p.Type,
-			Field:     p.Field,
-			Language:  p.Language,
-			Location:  p.Location,
+			Type:      current.Type,
+			Field:     current.Field,
+			Language:  current.Language,
+			Location:  current.Location,
+			Topic:     current.Topic,
 			CreatedAt: createdAt,
+		})
+		if cursor.RemainingBatchLength() == 0 {
+			if err := r.insert(ctx, current, last, ranks); err != nil {
+				return err
+			}
+			ranks = ranks[:0]
 		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return r.insert(ctx, current, last, ranks)
+}
+
+func (r *RankModel) insert(ctx context.Context, p pipeline.Pipeline, last time.Time, ranks []Rank) error {
+	if len(ranks) == 0 {
+		return nil
+	}
+	previous, err := r.previous(ctx, p, last, ranks)
+	if err != nil {
+		return err
+	}
+	var models []mongo.WriteModel
+	for _, rank := range ranks {
+		rank.compare(previous)
 		models = append(models, mongo.NewInsertOneModel().SetDocument(rank))
-		if cursor.RemainingBatchLength() == 0 {
-			database.BulkWrite(r.Name(), models)
-			models = models[:0]
+	}
+	_, err = database.BulkWrite(ctx, r.Name(), models)
+	return err
+}
+
+func (r *RankModel) lastCreatedAt(ctx context.Context, p pipeline.Pipeline, before time.Time) (time.Time, error) {
+	last := Rank{}
+	opts := options.FindOne().SetSort(bson.D{{"created_at", -1}}).SetProjection(bson.D{{"created_at", 1}})
+	res := database.FindOne(ctx, r.Name(), append(rankFilter(p), bson.E{Key: "created_at", Value: bson.D{{"$lt", before}}}), opts)
+	if err := res.Decode(&last); err != nil && err != mongo.ErrNoDocuments {
+		return time.Time{}, err
+	}
+	return last.CreatedAt, nil
+}
+
+func (r *RankModel) previous(ctx context.Context, p pipeline.Pipeline, last time.Time, ranks []Rank) (map[string]Rank, error) {
+	previous := make(map[string]Rank)
+	if last.IsZero() {
+		return previous, nil
+	}
+	var names []string
+	for _, rank := range ranks {
+		names = append(names, rank.Name)
+	}
+	filter := append(rankFilter(p), bson.E{Key: "created_at", Value: last}, bson.E{Key: "name", Value: operator.In(names)})
+	cursor, err := database.Find(ctx, r.Name(), filter)
+	if err != nil {
+		return nil, err
+	}
+	defer database.CloseCursor(ctx, cursor)
+	for cursor.Next(ctx) {
+		rank := Rank{}
+		if err := cursor.Decode(&rank); err != nil {
+			return nil, err
 		}
+		previous[rank.Name] = rank
 	}
+	return previous, cursor.Err()
 }
 
-func (r *RankModel) Delete(createdAt time.Time, rankType string) {
+// Prune deletes the ranks older than the retained snapshots, except the ones still published with the given creation times.
+func (r *RankModel) Prune(ctx context.Context, rankType string, published []time.Time) error {
+	cursor, err := database.Aggregate(ctx, r.Name(), pipeline.StaleRankSnapshot(rankType, retention()))
+	if err != nil {
+		return err
+	}
+	defer database.CloseCursor(ctx, cursor)
+
+	rec := struct {
+		CreatedAt time.Time `bson:"_id"`
+	}{}
+	if !cursor.Next(ctx) {
+		return cursor.Err()
+	}
+	if err := cursor.Decode(&rec); err != nil {
+		return err
+	}
+
 	filter := bson.D{
 		{"$and", []bson.D{{
 			{"type", rankType},
 			{"created_at", bson.D{
-				{"$lt", createdAt},
+				{"$lte", rec.CreatedAt},
+				{"$nin", published},
 			}},
 		}}},
 	}
-	database.DeleteMany(r.Name(), filter)
+	return database.DeleteMany(ctx, r.Name(), filter)
 }
 
-func (r *RankModel) Count(model Interface, p pipeline.Pipeline) int {
-	ctx := context.Background()
-	rec := struct {
-		Count int `bson:"count"`
-	}{}
-	cursor := database.Aggregate(ctx, model.Name(), pipeline.RankCount(*p.Pipeline))
+func (r *RankModel) Discard(ctx context.Context, createdAt time.Time, rankType string) error {
+	filter := bson.D{
+		{"$and", []bson.D{{
+			{"type", rankType},
+			{"created_at", createdAt},
+		}}},
+	}
+	return database.DeleteMany(ctx, r.Name(), filter)
+}
+
+func (r *RankModel) Export(ctx context.Context, snapshot request.Snapshot, encoder *json.Encoder) (int, error) {
+	filter := bson.D{
+		{"type", snapshot.Type},
+		{"created_at", snapshot.CreatedAt},
+	}
+	if len(snapshot.Fields) > 0 {
+		filter = append(filter, bson.E{Key: "field", Value: operator.In(snapshot.Fields)})
+	}
+	cursor, err := database.Find(ctx, r.Name(), filter)
+	if err != nil {
+		return 0, err
+	}
+	defer database.CloseCursor(ctx, cursor)
+
+	count := 0
+	for ; cursor.Next(ctx); count++ {
+		rank := Rank{}
+		if err := cursor.Decode(&rank); err != nil {
+			return count, err
+		}
+		if err := encoder.Encode(rank); err != nil {
+			return count, err
+		}
+	}
+	return count, cursor.Err()
+}
+
+func (r *RankModel) Count(ctx context.Context, model Interface, p pipeline.Pipeline) (map[[2]string]int, error) {
+	counts := make(map[[2]string]int)
+	cursor, err := database.Aggregate(ctx, model.Name(), pipeline.RankCount(p))
+	if err != nil {
+		return nil, err
+	}
 	defer database.CloseCursor(ctx, cursor)
 	for cursor.Next(ctx) {
+		rec := struct {
+			ID struct {
+				Language string `bson:"language"`
+				Location string `bson:"location"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}{}
 		if err := cursor.Decode(&rec); err != nil {
-			log.Fatal(err.Error())
+			return nil, err
 		}
+		s := p.Slice(rec.ID.Language, rec.ID.Location)
+		counts[[2]string{s.Language, s.Location}] = rec.Count
+	}
+	return counts, cursor.Err()
+}
+
+func rankFilter(p pipeline.Pipeline) bson.D {
+	filter := bson.D{
+		{"type", p.Type},
+		{"field", p.Field},
+		{"topic", pipeline.Topic(p.Topic)},
+	}
+	if !p.Sliced(pipeline.SliceLanguage) {
+		filter = append(filter, bson.E{Key: "language", Value: p.Language})
+	}
+	if !p.Sliced(pipeline.SliceLocation) {
+		filter = append(filter, bson.E{Key: "location", Value: p.Location})
+	}
+	return filter
+}
+
+func retention() int64 {
+	if n := viper.GetInt64("RANK_RETENTION"); n > 0 {
+		return n
 	}
-	return rec.Count
+	return 1
 }
 
 func NewRankModel() *RankModel {
@@ -2,13 +2,17 @@ package model
 
 import (
 	"context"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
 	"github.com/memochou1993/gh-rankings/app/handler/request"
 	"github.com/memochou1993/gh-rankings/app/pipeline"
+	"github.com/memochou1993/gh-rankings/app/pipeline/operator"
 	"github.com/memochou1993/gh-rankings/app/query"
 	"github.com/memochou1993/gh-rankings/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"log"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -24,6 +28,13 @@ type Repository struct {
 	PrimaryLanguage struct {
 		Name string `json:"name" bson:"name"`
 	} `json:"primaryLanguage" bson:"primary_language"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name" bson:"name"`
+			} `json:"topic" bson:"topic"`
+		} `json:"nodes" bson:"nodes"`
+	} `json:"repositoryTopics" bson:"repository_topics"`
 	Stargazers *query.Items `json:"stargazers" bson:"stargazers"`
 	Watchers   *query.Items `json:"watchers" bson:"watchers"`
 }
@@ -36,31 +47,30 @@ type RepositoryModel struct {
 	*Model
 }
 
-func (r *RepositoryModel) List(req *request.Repository) (repositories []Repository) {
-	ctx := context.Background()
-
+func (r *RepositoryModel) List(ctx context.Context, req *request.Repository) (repositories []Repository, err error) {
 	p := pipeline.ListRepositories(req)
 	if req.Q != "" {
 		p = pipeline.SearchRepositories(req)
 	}
 
-	cursor := database.Aggregate(ctx, r.Model.Name(), p)
-	repositories = make([]Repository, req.Limit)
-	if err := cursor.All(ctx, &repositories); err != nil {
-		log.Fatal(err.Error())
+	cursor, err := database.Aggregate(ctx, r.Model.Name(), p)
+	if err != nil {
+		return
 	}
+	repositories = make([]Repository, req.Limit)
+	err = cursor.All(ctx, &repositories)
 
 	return
 }
 
-func (r *RepositoryModel) FindByID(id string) (repository Repository) {
-	r.Model.FindByID(id, &repository)
+func (r *RepositoryModel) FindByID(ctx context.Context, id string) (repository Repository, err error) {
+	err = r.Model.FindByID(ctx, id, &repository)
 	return
 }
 
-func (r *RepositoryModel) Store(repositories []Repository) *mongo.BulkWriteResult {
+func (r *RepositoryModel) Store(ctx context.Context, repositories []Repository) (*mongo.BulkWriteResult, error) {
 	if len(repositories) == 0 {
-		return nil
+		return nil, nil
 	}
 	var models []mongo.WriteModel
 	for _, repository := range repositories {
@@ -68,7 +78,60 @@ func (r *RepositoryModel) Store(repositories []Repository) *mongo.BulkWriteResul
 		update := bson.D{{"$set", repository}}
 		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
 	}
-	return database.BulkWrite(r.Name(), models)
+	return database.BulkWrite(ctx, r.Name(), models)
+}
+
+// Rekey moves the repositories of a renamed owner and their ranks under the current login, keeping any already collected there.
+func (r *RepositoryModel) Rekey(ctx context.Context, previous, login string) error {
+	cursor, err := database.Find(ctx, r.Name(), bson.D{{"owner.login", previous}})
+	if err != nil {
+		return err
+	}
+	defer database.CloseCursor(ctx, cursor)
+	var models []mongo.WriteModel
+	for cursor.Next(ctx) {
+		repository := Repository{}
+		if err := cursor.Decode(&repository); err != nil {
+			return err
+		}
+		id := repository.ID()
+		repository.NameWithOwner = fmt.Sprintf("%s/%s", login, id[strings.Index(id, "/")+1:])
+		repository.Owner.Login = login
+		filter := bson.D{{"_id", repository.ID()}}
+		update := bson.D{{"$setOnInsert", repository}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+		models = append(models, mongo.NewDeleteOneModel().SetFilter(bson.D{{"_id", id}}))
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(models) > 0 {
+		if _, err := database.BulkWrite(ctx, r.Name(), models); err != nil {
+			return err
+		}
+	}
+	filter := bson.D{{"type", app.TypeRepository}, {"name", operator.Regex("^"+regexp.QuoteMeta(previous+"/"), "")}}
+	update := mongo.Pipeline{
+		operator.Set("name", bson.D{{"$concat", bson.A{
+			login,
+			bson.D{{"$substrCP", bson.A{"$name", len(previous), bson.D{{"$strLenCP", "$name"}}}}},
+		}}}),
+	}
+	_, err = NewRankModel().Collection().UpdateMany(ctx, filter, update)
+	return err
+}
+
+func unique(repositories []Repository) []Repository {
+	var items []Repository
+	seen := map[string]bool{}
+	for _, repository := range repositories {
+		if seen[repository.ID()] {
+			continue
+		}
+		seen[repository.ID()] = true
+		items = append(items, repository)
+	}
+	return items
 }
 
 func NewRepositoryModel() *RepositoryModel {
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"context"
+	"github.com/memochou1993/gh-rankings/app/handler/request"
+	"github.com/memochou1993/gh-rankings/app/pipeline"
+	"github.com/memochou1993/gh-rankings/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+)
+
+type WorkerRun struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Worker     string             `json:"worker" bson:"worker"`
+	Command    string             `json:"command" bson:"command"`
+	Status     string             `json:"status" bson:"status"`
+	StartedAt  time.Time          `json:"startedAt" bson:"started_at"`
+	FinishedAt time.Time          `json:"finishedAt" bson:"finished_at,omitempty"`
+	Processed  int                `json:"processed" bson:"processed"`
+	Error      string             `json:"error" bson:"error,omitempty"`
+	Snapshot   time.Time          `json:"snapshot" bson:"snapshot,omitempty"`
+	Staging    time.Time          `json:"staging" bson:"staging,omitempty"`
+	Fields     []FieldSnapshot    `json:"fields" bson:"fields,omitempty"`
+}
+
+// FieldSnapshot records the creation time of the ranks of a field which were not ranked again with the snapshot of the run.
+type FieldSnapshot struct {
+	Field     string    `json:"field" bson:"field"`
+	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
+}
+
+// CreatedAt returns the creation time of the published ranks of the given field.
+func (w WorkerRun) CreatedAt(field string) time.Time {
+	for _, f := range w.Fields {
+		if f.Field == field {
+			return f.CreatedAt
+		}
+	}
+	return w.Snapshot
+}
+
+// Timestamps returns the creation times of all the ranks the snapshot of the run is made up of.
+func (w WorkerRun) Timestamps() []time.Time {
+	timestamps := []time.Time{w.Snapshot}
+	for _, s := range w.Snapshots()[1:] {
+		timestamps = append(timestamps, s.CreatedAt)
+	}
+	return timestamps
+}
+
+// Snapshots returns the ranks the snapshot of the run is made up of, grouped by their creation time.
+func (w WorkerRun) Snapshots() []request.Snapshot {
+	snapshots := []request.Snapshot{{Type: w.Worker, CreatedAt: w.Snapshot}}
+	index := make(map[time.Time]int)
+	for _, f := range w.Fields {
+		i, ok := index[f.CreatedAt]
+		if !ok {
+			i = len(snapshots)
+			index[f.CreatedAt] = i
+			snapshots = append(snapshots, request.Snapshot{Type: w.Worker, CreatedAt: f.CreatedAt})
+		}
+		snapshots[i].Fields = append(snapshots[i].Fields, f.Field)
+	}
+	return snapshots
+}
+
+type WorkerRunModel struct {
+	*Model
+}
+
+func (w *WorkerRunModel) CreateIndexes(ctx context.Context) error {
+	return database.CreateIndexes(ctx, w.Name(), []string{"worker", "started_at", "snapshot", "staging"})
+}
+
+func (w *WorkerRunModel) List(ctx context.Context, limit int64) (runs []WorkerRun, err error) {
+	opts := options.Find().SetSort(bson.D{{"started_at", -1}}).SetLimit(limit)
+	cursor, err := database.Find(ctx, w.Name(), bson.D{}, opts)
+	if err != nil {
+		return
+	}
+	err = cursor.All(ctx, &runs)
+	return
+}
+
+func (w *WorkerRunModel) Snapshots(ctx context.Context) (runs []WorkerRun, err error) {
+	cursor, err := database.Aggregate(ctx, w.Name(), pipeline.PublishedSnapshots())
+	if err != nil {
+		return
+	}
+	err = cursor.All(ctx, &runs)
+	return
+}
+
+func (w *WorkerRunModel) Snapshot(ctx context.Context, worker string) (run WorkerRun, err error) {
+	filter := bson.D{
+		{"worker", worker},
+		{"snapshot", bson.D{{"$exists", true}}},
+	}
+	opts := options.FindOne().SetSort(bson.D{{"snapshot", -1}})
+	res := database.FindOne(ctx, w.Name(), filter, opts)
+	if err = res.Decode(&run); err == mongo.ErrNoDocuments {
+		err = nil
+	}
+	return
+}
+
+func (w *WorkerRunModel) Last(ctx context.Context, worker string, command string) (run WorkerRun, err error) {
+	filter := bson.D{
+		{"worker", worker},
+		{"command", command},
+	}
+	opts := options.FindOne().SetSort(bson.D{{"started_at", -1}})
+	res := database.FindOne(ctx, w.Name(), filter, opts)
+	if err = res.Decode(&run); err == mongo.ErrNoDocuments {
+		err = nil
+	}
+	return
+}
+
+// Abandoned returns the unpublished runs whose staging ranks can be discarded, either because they failed or were cancelled, or because they have been running since before the given time.
+func (w *WorkerRunModel) Abandoned(ctx context.Context, worker string, before time.Time) (runs []WorkerRun, err error) {
+	filter := bson.D{
+		{"worker", worker},
+		{"staging", bson.D{{"$exists", true}}},
+		{"snapshot", bson.D{{"$exists", false}}},
+		{"$or", bson.A{
+			bson.D{{"status", bson.D{{"$in", bson.A{RunStatusFailed, RunStatusCancelled}}}}},
+			bson.D{{"status", RunStatusRunning}, {"started_at", bson.D{{"$lt", before}}}},
+		}},
+	}
+	cursor, err := database.Find(ctx, w.Name(), filter)
+	if err != nil {
+		return
+	}
+	err = cursor.All(ctx, &runs)
+	return
+}
+
+func (w *WorkerRunModel) Unstage(ctx context.Context, run WorkerRun) error {
+	filter := bson.D{{"_id", run.ID}}
+	update := bson.D{{"$unset", bson.D{{"staging", ""}}}}
+	return database.UpdateOne(ctx, w.Name(), filter, update)
+}
+
+func (w *WorkerRunModel) Store(ctx context.Context, run WorkerRun) error {
+	filter := bson.D{{"_id", run.ID}}
+	update := bson.D{{"$set", run}}
+	return database.UpdateOne(ctx, w.Name(), filter, update, options.Update().SetUpsert(true))
+}
+
+func NewWorkerRunModel() *WorkerRunModel {
+	return &WorkerRunModel{
+		Model: &Model{
+			name: "worker_runs",
+		},
+	}
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"github.com/memochou1993/gh-rankings/app"
 	"github.com/memochou1993/gh-rankings/app/handler/request"
 	"github.com/memochou1993/gh-rankings/app/pipeline"
 	"github.com/memochou1993/gh-rankings/app/query"
@@ -9,21 +10,49 @@ import (
 	"github.com/memochou1993/gh-rankings/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
 type User struct {
-	ImageUrl       string       `json:"imageUrl" bson:"image_url"`
-	CreatedAt      *time.Time   `json:"createdAt" bson:"created_at"`
-	Followers      *query.Items `json:"followers" bson:"followers"`
-	Location       string       `json:"location" bson:"location"`
-	Login          string       `json:"login" bson:"_id"`
-	Name           string       `json:"name" bson:"name"`
-	Gists          []query.Gist `json:"gists,omitempty" bson:"gists,omitempty"`
-	Repositories   []Repository `json:"repositories,omitempty" bson:"repositories,omitempty"`
-	ParsedLocation string       `json:"parsedLocation" bson:"parsed_location"`
-	ParsedCity     string       `json:"parsedCity" bson:"parsed_city"`
+	ImageUrl                string         `json:"imageUrl" bson:"image_url"`
+	CreatedAt               *time.Time     `json:"createdAt" bson:"created_at"`
+	Followers               *query.Items   `json:"followers" bson:"followers"`
+	Location                string         `json:"location" bson:"location"`
+	Login                   string         `json:"login" bson:"login"`
+	NodeID                  string         `json:"id" bson:"node_id,omitempty"`
+	PreviousLogins          []string       `json:"previousLogins,omitempty" bson:"previous_logins,omitempty"`
+	Name                    string         `json:"name" bson:"name"`
+	Gists                   []query.Gist   `json:"gists,omitempty" bson:"gists,omitempty"`
+	Repositories            []Repository   `json:"repositories,omitempty" bson:"repositories,omitempty"`
+	ContributedRepositories []Repository   `json:"contributedRepositories,omitempty" bson:"contributed_repositories,omitempty"`
+	Contributions           *Contributions `json:"contributions,omitempty" bson:"contributions,omitempty"`
+	ParsedLocation          string         `json:"parsedLocation" bson:"parsed_location"`
+	ParsedCity              string         `json:"parsedCity" bson:"parsed_city"`
+	FetchedAt               *time.Time     `json:"fetchedAt" bson:"fetched_at"`
+	GoneAt                  *time.Time     `json:"goneAt,omitempty" bson:"gone_at,omitempty"`
+}
+
+type Contributions struct {
+	StartedAt    *time.Time              `json:"startedAt" bson:"started_at"`
+	EndedAt      *time.Time              `json:"endedAt" bson:"ended_at"`
+	Commits      *query.Items            `json:"commits" bson:"commits"`
+	Issues       *query.Items            `json:"issues" bson:"issues"`
+	PullRequests *query.Items            `json:"pullRequests" bson:"pull_requests"`
+	Reviews      *query.Items            `json:"reviews" bson:"reviews"`
+	Restricted   *query.Items            `json:"restricted" bson:"restricted"`
+	Repositories []ContributedRepository `json:"repositories,omitempty" bson:"repositories,omitempty"`
+}
+
+type ContributedRepository struct {
+	NameWithOwner   string `json:"nameWithOwner" bson:"name_with_owner"`
+	PrimaryLanguage struct {
+		Name string `json:"name" bson:"name"`
+	} `json:"primaryLanguage" bson:"primary_language"`
+	Commits      *query.Items `json:"commits" bson:"commits"`
+	Issues       *query.Items `json:"issues" bson:"issues"`
+	PullRequests *query.Items `json:"pullRequests" bson:"pull_requests"`
+	Reviews      *query.Items `json:"reviews" bson:"reviews"`
 }
 
 func (u *User) ID() string {
@@ -38,52 +67,120 @@ type UserModel struct {
 	*Model
 }
 
-func (u *UserModel) List(req *request.User) (users []User) {
-	ctx := context.Background()
-
+func (u *UserModel) List(ctx context.Context, req *request.User) (users []User, err error) {
 	p := pipeline.ListUsers(req)
 	if req.Q != "" {
 		p = pipeline.SearchUsers(req)
 	}
 
-	cursor := database.Aggregate(ctx, u.Model.Name(), p)
-	users = make([]User, req.Limit)
-	if err := cursor.All(ctx, &users); err != nil {
-		log.Fatal(err.Error())
+	cursor, err := database.Aggregate(ctx, u.Model.Name(), p)
+	if err != nil {
+		return
 	}
+	users = make([]User, req.Limit)
+	err = cursor.All(ctx, &users)
 
 	return
 }
 
-func (u *UserModel) FindByID(id string) (user User) {
-	u.Model.FindByID(id, &user)
+func (u *UserModel) FindByLogin(ctx context.Context, login string) (user User, err error) {
+	err = u.Model.FindByLogin(ctx, login, &user)
 	return
 }
 
-func (u *UserModel) Store(users []User) *mongo.BulkWriteResult {
+func (u *UserModel) FindByPreviousLogin(ctx context.Context, login string) (user User, err error) {
+	err = u.Model.FindByPreviousLogin(ctx, login, &user)
+	return
+}
+
+func (u *UserModel) Store(ctx context.Context, users []User) (*mongo.BulkWriteResult, error) {
 	if len(users) == 0 {
-		return nil
+		return nil, nil
+	}
+	accounts := map[string]string{}
+	for _, user := range users {
+		if user.NodeID != "" {
+			accounts[user.NodeID] = user.ID()
+		}
+	}
+	renamed, err := u.Model.renamed(ctx, accounts)
+	if err != nil {
+		return nil, err
 	}
 	var models []mongo.WriteModel
+	fetchedAt := time.Now()
 	for _, user := range users {
 		user.parseLocation()
-		filter := bson.D{{"_id", user.ID()}}
-		update := bson.D{{"$set", user}}
+		if user.FetchedAt == nil {
+			user.FetchedAt = &fetchedAt
+		}
+		user.PreviousLogins = nil
+		user.GoneAt = nil
+		filter := account(user.ID(), user.NodeID)
+		update := bson.D{{"$set", user}, {"$unset", bson.D{{"gone_at", ""}}}}
+		if previous, ok := renamed[user.ID()]; ok {
+			update = append(update, rename(user.ID(), previous))
+		}
 		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
 	}
-	return database.BulkWrite(u.Name(), models)
+	res, err := database.BulkWrite(ctx, u.Name(), models)
+	if err != nil {
+		return nil, err
+	}
+	return res, u.Model.relink(ctx, app.TypeUser, renamed)
 }
 
-func (u *UserModel) UpdateGists(user User, gists []query.Gist) {
-	filter := bson.D{{"_id", user.ID()}}
+func (u *UserModel) UpdateGists(ctx context.Context, user User, gists []query.Gist) error {
+	filter := bson.D{{"login", user.ID()}}
 	update := bson.D{{"$set", bson.D{{"gists", gists}}}}
-	database.UpdateOne(u.Name(), filter, update)
+	return database.UpdateOne(ctx, u.Name(), filter, update)
 }
 
-func (u *UserModel) UpdateRepositories(user User, repositories []Repository) {
-	filter := bson.D{{"_id", user.ID()}}
+func (u *UserModel) UpdateRepositories(ctx context.Context, user User, repositories []Repository) error {
+	filter := bson.D{{"login", user.ID()}}
 	update := bson.D{{"$set", bson.D{{"repositories", repositories}}}}
-	database.UpdateOne(u.Name(), filter, update)
+	return database.UpdateOne(ctx, u.Name(), filter, update)
+}
+
+func (u *UserModel) UpdateContributedRepositories(ctx context.Context, user User, repositories []Repository) error {
+	filter := bson.D{{"login", user.ID()}}
+	update := bson.D{{"$set", bson.D{{"contributed_repositories", unique(repositories)}}}}
+	return database.UpdateOne(ctx, u.Name(), filter, update)
+}
+
+func (u *UserModel) UpdateContributions(ctx context.Context, user User, contributions *Contributions) error {
+	filter := bson.D{{"login", user.ID()}}
+	update := bson.D{{"$set", bson.D{{"contributions", contributions}}}}
+	return database.UpdateOne(ctx, u.Name(), filter, update)
+}
+
+func (u *UserModel) CreateIndexes(ctx context.Context) error {
+	return database.CreateIndexes(ctx, u.Name(), []string{"fetched_at", "login", "node_id", "previous_logins"})
+}
+
+func (u *UserModel) Stale(ctx context.Context, before time.Time, limit int64) (users []User, err error) {
+	filter := bson.D{{"fetched_at", bson.D{{"$not", bson.D{{"$gte", before}}}}}}
+	opts := options.Find().SetSort(bson.D{{"fetched_at", 1}}).SetProjection(bson.D{{"login", 1}, {"node_id", 1}}).SetLimit(limit)
+	cursor, err := database.Find(ctx, u.Name(), filter, opts)
+	if err != nil {
+		return
+	}
+	defer database.CloseCursor(ctx, cursor)
+	for cursor.Next(ctx) {
+		user := User{}
+		if err = cursor.Decode(&user); err != nil {
+			return
+		}
+		users = append(users, user)
+	}
+	err = cursor.Err()
+	return
+}
+
+func (u *UserModel) Touch(ctx context.Context, logins []string) error {
+	filter := bson.D{{"login", bson.D{{"$in", logins}}}}
+	update := bson.D{{"$set", bson.D{{"fetched_at", time.Now()}}}}
+	return database.UpdateMany(ctx, u.Name(), filter, update)
 }
 
 func NewUserModel() *UserModel {
@@ -26,6 +26,14 @@ func Project(v interface{}) bson.D {
 	}
 }
 
+func Set(field string, v interface{}) bson.D {
+	return bson.D{
+		{"$set", bson.D{
+			{field, v},
+		}},
+	}
+}
+
 func First(field string) bson.D {
 	return bson.D{
 		{"$first", field},
@@ -81,3 +89,33 @@ func Regex(pattern, options string) bson.D {
 		{"$regex", primitive.Regex{Pattern: pattern, Options: options}},
 	}
 }
+
+func ReplaceRoot(field string) bson.D {
+	return bson.D{
+		{"$replaceRoot", bson.D{
+			{"newRoot", fmt.Sprintf("$%s", field)},
+		}},
+	}
+}
+
+func Lookup(from, localField, foreignField, as string, pipeline []bson.D) bson.D {
+	return bson.D{
+		{"$lookup", bson.D{
+			{"from", from},
+			{"localField", localField},
+			{"foreignField", foreignField},
+			{"pipeline", pipeline},
+			{"as", as},
+		}},
+	}
+}
+
+func ConcatArrays(fields ...string) bson.D {
+	var arrays []string
+	for _, field := range fields {
+		arrays = append(arrays, fmt.Sprintf("$%s", field))
+	}
+	return bson.D{
+		{"$concatArrays", arrays},
+	}
+}
@@ -19,14 +19,16 @@ func RankOrganization() (pipelines []*Pipeline) {
 		pipelines = append(pipelines, rankByField(rankType, field))
 		pipelines = append(pipelines, rankByLocation(rankType, field)...)
 		pipelines = append(pipelines, rankOwnerRepositoryByLanguage(rankType, field)...)
+		pipelines = append(pipelines, rankOwnerRepositoryByTopic(rankType, field)...)
+		pipelines = append(pipelines, rankOwnerRepositoryByLanguageAndLocation(rankType, field)...)
 	}
-	return
+	return present(pipelines)
 }
 
 func SearchOrganizations(req *request.Organization) mongo.Pipeline {
 	cond := mongo.Pipeline{}
 	if req.Q != "" {
-		cond = append(cond, bson.D{{"_id", operator.Regex(req.Q, "i")}})
+		cond = append(cond, bson.D{{"login", operator.Regex(req.Q, "i")}})
 	}
 	return mongo.Pipeline{
 		operator.Match("$or", cond),
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"github.com/memochou1993/gh-rankings/app/pipeline/operator"
 	"github.com/memochou1993/gh-rankings/app/resource"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
 )
 
 const (
@@ -13,20 +15,55 @@ const (
 	descending = -1
 )
 
+const (
+	SliceLanguage = "language"
+	SliceLocation = "location"
+)
+
 type Pipeline struct {
 	Pipeline *mongo.Pipeline
 	Type     string
 	Field    string
 	Language string
 	Location string
+	Topic    string
+	Slices   []string
+}
+
+func (p Pipeline) Sliced(key string) bool {
+	for _, slice := range p.Slices {
+		if slice == key {
+			return true
+		}
+	}
+	return false
 }
 
-func RankCount(pipeline mongo.Pipeline) mongo.Pipeline {
+func (p Pipeline) Slice(language, location string) Pipeline {
+	if p.Sliced(SliceLanguage) {
+		p.Language = language
+	}
+	if p.Sliced(SliceLocation) {
+		p.Location = location
+	}
+	p.Slices = nil
+	return p
+}
+
+// RankCount counts the ranked records of each slice, grouping only by the dimensions the pipeline is sliced by.
+func RankCount(p Pipeline) mongo.Pipeline {
+	id := bson.D{}
+	for _, slice := range p.Slices {
+		id = append(id, bson.E{Key: slice, Value: "$" + slice})
+	}
 	stages := mongo.Pipeline{
 		operator.Match("total_count", bson.D{{"$gt", 0}}),
-		operator.Count(),
+		operator.Group(bson.D{
+			{"_id", id},
+			{"count", bson.D{{"$sum", 1}}},
+		}),
 	}
-	return append(pipeline, stages...)
+	return append(append(mongo.Pipeline{}, *p.Pipeline...), stages...)
 }
 
 func rankByField(rankType string, field string) *Pipeline {
@@ -82,11 +119,12 @@ func rankByLocation(rankType string, field string) (pipelines []*Pipeline) {
 }
 
 func rankOwnerRepositoryByLanguage(rankType string, field string) (pipelines []*Pipeline) {
+	path := field[:strings.LastIndex(field, ".")]
 	for _, language := range resource.Languages {
 		pipelines = append(pipelines, &Pipeline{
 			Pipeline: &mongo.Pipeline{
-				operator.Unwind("repositories"),
-				operator.Match("repositories.primary_language.name", language.Name),
+				operator.Unwind(path),
+				operator.Match(fmt.Sprintf("%s.primary_language.name", path), language.Name),
 				operator.Group(bson.D{
 					id(),
 					{"image_url", operator.First("$image_url")},
@@ -102,6 +140,94 @@ func rankOwnerRepositoryByLanguage(rankType string, field string) (pipelines []*
 	return
 }
 
+func rankOwnerRepositoryByTopic(rankType string, field string) (pipelines []*Pipeline) {
+	path := field[:strings.LastIndex(field, ".")]
+	for _, topic := range resource.Topics {
+		pipelines = append(pipelines, &Pipeline{
+			Pipeline: &mongo.Pipeline{
+				operator.Unwind(path),
+				operator.Match(fmt.Sprintf("%s.repository_topics.nodes.topic.name", path), topic.Name),
+				operator.Group(bson.D{
+					id(),
+					{"image_url", operator.First("$image_url")},
+					totalCount(field),
+				}),
+				operator.Sort("total_count", descending),
+			},
+			Type:  rankType,
+			Field: field,
+			Topic: topic.Name,
+		})
+	}
+	return
+}
+
+func rankOwnerRepositoryByLanguageAndLocation(rankType string, field string) (pipelines []*Pipeline) {
+	languages, locations := subset("RANK_LANGUAGES"), subset("RANK_LOCATIONS")
+	if len(languages) == 0 || len(locations) == 0 {
+		return
+	}
+	path := field[:strings.LastIndex(field, ".")]
+	language := fmt.Sprintf("%s.primary_language.name", path)
+	slices := []string{SliceLocation, SliceLanguage}
+	pipelines = append(pipelines, &Pipeline{
+		Pipeline: &mongo.Pipeline{
+			operator.Match("$or", bson.A{
+				bson.D{{"parsed_location", operator.In(locations)}},
+				bson.D{{"parsed_city", operator.In(locations)}},
+			}),
+			operator.Set(SliceLocation, bson.A{"$parsed_location", "$parsed_city"}),
+			operator.Unwind(path),
+			operator.Match(language, operator.In(languages)),
+			operator.Group(bson.D{
+				{"_id", bson.D{
+					{"login", "$_id"},
+					{SliceLanguage, "$" + language},
+				}},
+				{"image_url", operator.First("$image_url")},
+				{SliceLocation, operator.First("$" + SliceLocation)},
+				totalCount(field),
+			}),
+			operator.Match("total_count", bson.D{{"$gt", 0}}),
+			operator.Project(bson.D{
+				{"_id", "$_id.login"},
+				imageUrl(),
+				{"total_count", 1},
+				{SliceLanguage, "$_id.language"},
+				{SliceLocation, 1},
+			}),
+			operator.Unwind(SliceLocation),
+			operator.Match(SliceLocation, operator.In(locations)),
+			sortSlices(slices),
+		},
+		Type:   rankType,
+		Field:  field,
+		Slices: slices,
+	})
+	return
+}
+
+func rankContributionByLanguage(rankType string, field string) (pipelines []*Pipeline) {
+	for _, language := range resource.Languages {
+		pipelines = append(pipelines, &Pipeline{
+			Pipeline: &mongo.Pipeline{
+				operator.Unwind("contributions.repositories"),
+				operator.Match("contributions.repositories.primary_language.name", language.Name),
+				operator.Group(bson.D{
+					id(),
+					{"image_url", operator.First("$image_url")},
+					totalCount(strings.Replace(field, "contributions.", "contributions.repositories.", 1)),
+				}),
+				operator.Sort("total_count", descending),
+			},
+			Type:     rankType,
+			Field:    field,
+			Language: language.Name,
+		})
+	}
+	return
+}
+
 func rankRepositoryByLanguage(rankType string, field string) (pipelines []*Pipeline) {
 	for _, language := range resource.Languages {
 		pipelines = append(pipelines, &Pipeline{
@@ -122,6 +248,125 @@ func rankRepositoryByLanguage(rankType string, field string) (pipelines []*Pipel
 	return
 }
 
+func rankRepositoryByTopic(rankType string, field string) (pipelines []*Pipeline) {
+	for _, topic := range resource.Topics {
+		pipelines = append(pipelines, &Pipeline{
+			Pipeline: &mongo.Pipeline{
+				operator.Match("repository_topics.nodes.topic.name", topic.Name),
+				operator.Project(bson.D{
+					id(),
+					imageUrl(),
+					totalCount(field),
+				}),
+				operator.Sort("total_count", descending),
+			},
+			Type:  rankType,
+			Field: field,
+			Topic: topic.Name,
+		})
+	}
+	return
+}
+
+func rankRepositoryByLocation(rankType string, field string) (pipelines []*Pipeline) {
+	for _, slices := range [][]string{{SliceLocation}, {SliceLocation, SliceLanguage}} {
+		p := repositoryLocation(field, slices)
+		pipelines = append(pipelines, &Pipeline{
+			Pipeline: &p,
+			Type:     rankType,
+			Field:    field,
+			Slices:   slices,
+		})
+	}
+	return
+}
+
+func rankRepositoryByTopicAndLocation(rankType string, field string) (pipelines []*Pipeline) {
+	slices := []string{SliceLocation}
+	for _, topic := range resource.Topics {
+		p := append(mongo.Pipeline{
+			operator.Match("repository_topics.nodes.topic.name", topic.Name),
+		}, repositoryLocation(field, slices)...)
+		pipelines = append(pipelines, &Pipeline{
+			Pipeline: &p,
+			Type:     rankType,
+			Field:    field,
+			Topic:    topic.Name,
+			Slices:   slices,
+		})
+	}
+	return
+}
+
+// repositoryLocation locates repositories by the parsed locations and cities of their owners.
+func repositoryLocation(field string, slices []string) mongo.Pipeline {
+	fields := bson.D{
+		id(),
+		imageUrl(),
+		totalCount(field),
+		{SliceLocation, operator.ConcatArrays(
+			"users.parsed_location",
+			"users.parsed_city",
+			"organizations.parsed_location",
+			"organizations.parsed_city",
+		)},
+	}
+	if len(slices) > 1 {
+		fields = append(fields, bson.E{Key: SliceLanguage, Value: "$primary_language.name"})
+	}
+	p := mongo.Pipeline{
+		operator.Lookup("users", "owner.login", "login", "users", ownerLocation()),
+		operator.Lookup("organizations", "owner.login", "login", "organizations", ownerLocation()),
+		operator.Project(fields),
+		operator.Unwind(SliceLocation),
+		operator.Match(SliceLocation, bson.D{{"$ne", ""}}),
+	}
+	if len(slices) > 1 {
+		p = append(p, operator.Match(SliceLanguage, bson.D{{"$nin", bson.A{nil, ""}}}))
+	}
+	return append(p, sortSlices(slices))
+}
+
+// present skips gone accounts and ranks the rest under their login, since owners are keyed on their node ID.
+func present(pipelines []*Pipeline) []*Pipeline {
+	for _, p := range pipelines {
+		stages := mongo.Pipeline{
+			operator.Match("gone_at", bson.D{{"$exists", false}}),
+			operator.Set("_id", "$login"),
+		}
+		*p.Pipeline = append(stages, *p.Pipeline...)
+	}
+	return pipelines
+}
+
+func ownerLocation() []bson.D {
+	return []bson.D{
+		operator.Project(bson.D{
+			{"parsed_location", 1},
+			{"parsed_city", 1},
+		}),
+	}
+}
+
+func sortSlices(slices []string) bson.D {
+	keys := bson.D{}
+	for _, slice := range slices {
+		keys = append(keys, bson.E{Key: slice, Value: ascending})
+	}
+	return bson.D{
+		{"$sort", append(keys, bson.E{Key: "total_count", Value: descending})},
+	}
+}
+
+func subset(key string) (values []string) {
+	for _, value := range strings.Split(viper.GetString(key), ";") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return
+}
+
 func id() bson.E {
 	return bson.E{
 		Key:   "_id",
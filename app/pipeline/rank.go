@@ -13,8 +13,8 @@ func SearchRanks(req *request.Rank) mongo.Pipeline {
 		{"field", req.Field},
 		{"language", req.Language},
 		{"location", req.Location},
-		{"created_at", operator.In(req.Timestamps)},
-	}}
+		{"topic", Topic(req.Topic)},
+	}, snapshots(req.Snapshots)}
 	if req.Name != "" {
 		cond = append(cond, bson.D{{"name", operator.Regex(req.Name, "i")}})
 	}
@@ -26,9 +26,9 @@ func SearchRanks(req *request.Rank) mongo.Pipeline {
 }
 
 func ListRanks(req *request.Rank) mongo.Pipeline {
-	cond := mongo.Pipeline{{
-		{"created_at", operator.In(req.Timestamps)},
-	}}
+	cond := mongo.Pipeline{
+		snapshots(req.Snapshots),
+	}
 	if req.Name != "" {
 		cond = append(cond, bson.D{{"name", req.Name}})
 	}
@@ -44,9 +44,72 @@ func ListRanks(req *request.Rank) mongo.Pipeline {
 	if req.Location != "" {
 		cond = append(cond, bson.D{{"location", req.Location}})
 	}
+	if req.Topic != "" {
+		cond = append(cond, bson.D{{"topic", req.Topic}})
+	}
 	return mongo.Pipeline{
 		operator.Match("$and", cond),
 		operator.Skip((req.Page - 1) * req.Limit),
 		operator.Limit(req.Limit),
 	}
 }
+
+func RankHistory(req *request.RankHistory) mongo.Pipeline {
+	cond := mongo.Pipeline{{
+		{"name", req.Name},
+		{"type", req.Type},
+		{"field", req.Field},
+		{"language", req.Language},
+		{"location", req.Location},
+		{"topic", Topic(req.Topic)},
+		{"created_at", bson.D{{"$lte", req.Timestamp}}},
+	}}
+	return mongo.Pipeline{
+		operator.Match("$and", cond),
+		operator.Sort("created_at", ascending),
+	}
+}
+
+func StaleRankSnapshot(rankType string, retention int64) mongo.Pipeline {
+	return mongo.Pipeline{
+		operator.Match("type", rankType),
+		operator.Group(bson.D{{"_id", "$created_at"}}),
+		operator.Sort("_id", descending),
+		operator.Skip(retention),
+		operator.Limit(1),
+	}
+}
+
+func PublishedSnapshots() mongo.Pipeline {
+	return mongo.Pipeline{
+		operator.Match("snapshot", bson.D{{"$exists", true}}),
+		operator.Sort("snapshot", descending),
+		operator.Group(bson.D{
+			{"_id", "$worker"},
+			{"run", operator.First("$$ROOT")},
+		}),
+		operator.ReplaceRoot("run"),
+	}
+}
+
+func snapshots(snapshots []request.Snapshot) bson.D {
+	var cond bson.A
+	for _, s := range snapshots {
+		c := bson.D{
+			{"type", s.Type},
+			{"created_at", s.CreatedAt},
+		}
+		if len(s.Fields) > 0 {
+			c = append(c, bson.E{Key: "field", Value: operator.In(s.Fields)})
+		}
+		cond = append(cond, c)
+	}
+	return bson.D{{"$or", cond}}
+}
+
+func Topic(name string) interface{} {
+	if name == "" {
+		return nil
+	}
+	return name
+}
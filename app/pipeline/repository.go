@@ -18,6 +18,9 @@ func RankRepository() (pipelines []*Pipeline) {
 	for _, field := range fields {
 		pipelines = append(pipelines, rankByField(rankType, field))
 		pipelines = append(pipelines, rankRepositoryByLanguage(rankType, field)...)
+		pipelines = append(pipelines, rankRepositoryByTopic(rankType, field)...)
+		pipelines = append(pipelines, rankRepositoryByLocation(rankType, field)...)
+		pipelines = append(pipelines, rankRepositoryByTopicAndLocation(rankType, field)...)
 	}
 	return
 }
@@ -17,6 +17,12 @@ func RankUser() (pipelines []*Pipeline) {
 		"repositories.forks",
 		"repositories.stargazers",
 		"repositories.watchers",
+		"contributed_repositories.stargazers",
+		"contributions.commits",
+		"contributions.issues",
+		"contributions.pull_requests",
+		"contributions.reviews",
+		"contributions.restricted",
 	}
 	for _, field := range fields {
 		pipelines = append(pipelines, rankByField(rankType, field))
@@ -25,17 +31,27 @@ func RankUser() (pipelines []*Pipeline) {
 	pipelines = append(pipelines, rankOwnerRepositoryByLanguage(rankType, "repositories.stargazers")...)
 	pipelines = append(pipelines, rankOwnerRepositoryByLanguage(rankType, "repositories.forks")...)
 	pipelines = append(pipelines, rankOwnerRepositoryByLanguage(rankType, "repositories.watchers")...)
-	return
+	pipelines = append(pipelines, rankOwnerRepositoryByLanguageAndLocation(rankType, "repositories.stargazers")...)
+	pipelines = append(pipelines, rankOwnerRepositoryByLanguageAndLocation(rankType, "repositories.forks")...)
+	pipelines = append(pipelines, rankOwnerRepositoryByLanguageAndLocation(rankType, "repositories.watchers")...)
+	pipelines = append(pipelines, rankOwnerRepositoryByLanguage(rankType, "contributed_repositories.stargazers")...)
+	pipelines = append(pipelines, rankOwnerRepositoryByTopic(rankType, "repositories.stargazers")...)
+	pipelines = append(pipelines, rankOwnerRepositoryByTopic(rankType, "contributed_repositories.stargazers")...)
+	pipelines = append(pipelines, rankContributionByLanguage(rankType, "contributions.commits")...)
+	pipelines = append(pipelines, rankContributionByLanguage(rankType, "contributions.issues")...)
+	pipelines = append(pipelines, rankContributionByLanguage(rankType, "contributions.pull_requests")...)
+	pipelines = append(pipelines, rankContributionByLanguage(rankType, "contributions.reviews")...)
+	return present(pipelines)
 }
 
 func SearchUsers(req *request.User) mongo.Pipeline {
 	cond := mongo.Pipeline{}
 	if req.Q != "" {
-		cond = append(cond, bson.D{{"_id", operator.Regex(req.Q, "i")}})
+		cond = append(cond, bson.D{{"login", operator.Regex(req.Q, "i")}})
 	}
 	return mongo.Pipeline{
 		operator.Match("$or", cond),
-		operator.Project(bson.D{{"repositories", 0}, {"gists", 0}}),
+		operator.Project(bson.D{{"repositories", 0}, {"gists", 0}, {"contributed_repositories", 0}, {"contributions.repositories", 0}}),
 		operator.Skip((req.Page - 1) * req.Limit),
 		operator.Limit(req.Limit),
 	}
@@ -43,7 +59,7 @@ func SearchUsers(req *request.User) mongo.Pipeline {
 
 func ListUsers(req *request.User) mongo.Pipeline {
 	return mongo.Pipeline{
-		operator.Project(bson.D{{"repositories", 0}, {"gists", 0}}),
+		operator.Project(bson.D{{"repositories", 0}, {"gists", 0}, {"contributed_repositories", 0}, {"contributions.repositories", 0}}),
 		operator.Skip((req.Page - 1) * req.Limit),
 		operator.Limit(req.Limit),
 	}
@@ -6,46 +6,92 @@ import (
 	"github.com/memochou1993/gh-rankings/util"
 	"io/ioutil"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 )
 
+type Variables map[string]interface{}
+
 type Query struct {
 	Schema string
-	Type   string
 	*SearchArguments
 	*OwnerArguments
 	*GistsArguments
 	*RepositoriesArguments
 }
 
+func (q Query) Variables() Variables {
+	variables := Variables{}
+	for _, arguments := range []interface{}{
+		q.SearchArguments,
+		q.OwnerArguments,
+		q.GistsArguments,
+		q.RepositoriesArguments,
+	} {
+		variables.merge(arguments)
+	}
+	return variables
+}
+
 func (q Query) String() string {
-	query := q.Schema
-	query = strings.Replace(query, "<Type>", q.Type, 1)
-	query = strings.Replace(query, "<SearchArguments>", util.ParseStruct(q.SearchArguments, ","), 1)
-	query = strings.Replace(query, "<OwnerArguments>", util.ParseStruct(q.OwnerArguments, ","), 1)
-	query = strings.Replace(query, "<GistsArguments>", util.ParseStruct(q.GistsArguments, ","), 1)
-	query = strings.Replace(query, "<RepositoriesArguments>", util.ParseStruct(q.RepositoriesArguments, ","), 1)
+	return payload(q.Schema, q.Variables())
+}
 
-	payload := struct {
-		Query string `json:"query"`
-	}{
-		Query: query,
+type Batch struct {
+	Name     string
+	Schema   string
+	Field    string
+	Fragment string
+	Logins   []string
+	NodeIDs  []string
+	*ContributionsArguments
+}
+
+// nodeID returns the node ID to look up the i-th owner with, or an empty string to look it up by login.
+func (b Batch) nodeID(i int) string {
+	if i < len(b.NodeIDs) {
+		return b.NodeIDs[i]
 	}
-	b, err := json.Marshal(payload)
-	if err != nil {
-		log.Fatal(err.Error())
+	return ""
+}
+
+func (b Batch) Variables() Variables {
+	variables := Variables{}
+	for i, login := range b.Logins {
+		variables[Alias(i)] = login
+		if nodeID := b.nodeID(i); nodeID != "" {
+			variables[Alias(i)] = nodeID
+		}
 	}
+	variables.merge(b.ContributionsArguments)
+	return variables
+}
 
-	return string(b)
+func (b Batch) String() string {
+	var definitions []string
+	var owners []string
+	for i := range b.Logins {
+		if b.nodeID(i) != "" {
+			definitions = append(definitions, fmt.Sprintf("$%s: ID!", Alias(i)))
+			owners = append(owners, fmt.Sprintf("  %s: node(id: $%s) { ...%s }", Alias(i), Alias(i), b.Fragment))
+			continue
+		}
+		definitions = append(definitions, fmt.Sprintf("$%s: String!", Alias(i)))
+		owners = append(owners, fmt.Sprintf("  %s: %s(login: $%s) { ...%s }", Alias(i), b.Field, Alias(i), b.Fragment))
+	}
+	if b.ContributionsArguments != nil {
+		definitions = append(definitions, "$from: DateTime!", "$to: DateTime!")
+	}
+	query := fmt.Sprintf("query %s(%s) {\n%s\n  rateLimit { ...rateLimit }\n}\n\n%s", b.Name, strings.Join(definitions, ", "), strings.Join(owners, "\n"), b.Schema)
+
+	return payload(query, b.Variables())
 }
 
 type SearchArguments struct {
 	After string `json:"after,omitempty"`
-	First int    `json:"first,omitempty"`
-	Query string `json:"query,omitempty"`
-	Type  string `json:"type,omitempty"`
+	First int    `json:"first"`
+	Query string `json:"query"`
+	Type  string `json:"type"`
 }
 
 func (s *SearchArguments) SetQuery(q *SearchQuery) {
@@ -53,20 +99,30 @@ func (s *SearchArguments) SetQuery(q *SearchQuery) {
 }
 
 type OwnerArguments struct {
-	Login string `json:"login,omitempty"`
+	Login string `json:"login"`
 }
 
 type GistsArguments struct {
 	After   string `json:"after,omitempty"`
-	First   int    `json:"first,omitempty"`
-	OrderBy string `json:"orderBy,omitempty"`
+	First   int    `json:"first"`
+	OrderBy *Order `json:"orderBy,omitempty"`
 }
 
 type RepositoriesArguments struct {
-	After             string `json:"after,omitempty"`
-	First             int    `json:"first,omitempty"`
-	OrderBy           string `json:"orderBy,omitempty"`
-	OwnerAffiliations string `json:"ownerAffiliations,omitempty"`
+	After             string   `json:"after,omitempty"`
+	First             int      `json:"first"`
+	OrderBy           *Order   `json:"orderBy,omitempty"`
+	OwnerAffiliations []string `json:"ownerAffiliations,omitempty"`
+}
+
+type ContributionsArguments struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type Order struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"`
 }
 
 type SearchQuery struct {
@@ -82,7 +138,7 @@ type SearchQuery struct {
 }
 
 func (s SearchQuery) String() string {
-	return strconv.Quote(util.ParseStruct(s, " "))
+	return util.ParseStruct(s, " ")
 }
 
 type Gist struct {
@@ -114,7 +170,7 @@ func OwnerGists() *Query {
 		OwnerArguments: &OwnerArguments{},
 		GistsArguments: &GistsArguments{
 			First:   100,
-			OrderBy: "{field:CREATED_AT,direction:ASC}",
+			OrderBy: &Order{Field: "CREATED_AT", Direction: "ASC"},
 		},
 	}
 }
@@ -125,8 +181,19 @@ func OwnerRepositories() *Query {
 		OwnerArguments: &OwnerArguments{},
 		RepositoriesArguments: &RepositoriesArguments{
 			First:             100,
-			OrderBy:           "{field:CREATED_AT,direction:ASC}",
-			OwnerAffiliations: "OWNER",
+			OrderBy:           &Order{Field: "CREATED_AT", Direction: "ASC"},
+			OwnerAffiliations: []string{"OWNER"},
+		},
+	}
+}
+
+func OwnerContributedRepositories() *Query {
+	return &Query{
+		Schema:         read("owner_contributed_repositories"),
+		OwnerArguments: &OwnerArguments{},
+		RepositoriesArguments: &RepositoriesArguments{
+			First:   100,
+			OrderBy: &Order{Field: "CREATED_AT", Direction: "ASC"},
 		},
 	}
 }
@@ -141,6 +208,37 @@ func Repositories() *Query {
 	}
 }
 
+func OwnersByLogin(logins []string) *Batch {
+	return &Batch{
+		Name:     "OwnersByLogin",
+		Schema:   read("owners_by_login"),
+		Field:    "repositoryOwner",
+		Fragment: "owner",
+		Logins:   logins,
+	}
+}
+
+func OwnerResources(ownerType string, logins []string) *Batch {
+	return &Batch{
+		Name:     fmt.Sprintf("%sResources", strings.Title(ownerType)),
+		Schema:   read(fmt.Sprintf("%s_resources", ownerType)),
+		Field:    ownerType,
+		Fragment: "resources",
+		Logins:   logins,
+	}
+}
+
+func Contributions(from, to time.Time) *ContributionsArguments {
+	return &ContributionsArguments{
+		From: from,
+		To:   to,
+	}
+}
+
+func Alias(i int) string {
+	return fmt.Sprintf("o%d", i)
+}
+
 func SearchSpecifiedUser(login string) *SearchQuery {
 	return &SearchQuery{
 		User: login,
@@ -181,6 +279,36 @@ func SearchRepositories(from, to time.Time) *SearchQuery {
 	}
 }
 
+func (v Variables) merge(arguments interface{}) {
+	b, err := json.Marshal(arguments)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fields := Variables{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		log.Fatal(err.Error())
+	}
+	for name, value := range fields {
+		v[name] = value
+	}
+}
+
+func payload(query string, variables Variables) string {
+	payload := struct {
+		Query     string    `json:"query"`
+		Variables Variables `json:"variables"`
+	}{
+		Query:     query,
+		Variables: variables,
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return string(b)
+}
+
 func read(name string) string {
 	b, err := ioutil.ReadFile(fmt.Sprintf("%s/assets/query/%s.graphql", util.Root(), name))
 	if err != nil {
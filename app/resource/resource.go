@@ -14,6 +14,7 @@ var (
 	SpecifiedUsers         []SpecifiedOwner
 	Languages              []Language
 	Locations              []Location
+	Topics                 []Topic
 )
 
 type SpecifiedOwner struct {
@@ -24,6 +25,10 @@ type Language struct {
 	Name string
 }
 
+type Topic struct {
+	Name string
+}
+
 type Location struct {
 	Name    string
 	Aliases []Location
@@ -48,6 +53,7 @@ func init() {
 	read("specified_user", &SpecifiedUsers)
 	read("language", &Languages)
 	read("location", &Locations)
+	read("topic", &Topics)
 }
 
 func Locate(text string) (location, city string) {
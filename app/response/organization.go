@@ -8,7 +8,8 @@ import (
 type Organization struct {
 	Data struct {
 		Search struct {
-			Edges []struct {
+			UserCount int `json:"userCount"`
+			Edges     []struct {
 				Cursor string             `json:"cursor"`
 				Node   model.Organization `json:"node"`
 			} `json:"edges"`
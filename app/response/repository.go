@@ -5,7 +5,8 @@ import "github.com/memochou1993/gh-rankings/app/model"
 type Repository struct {
 	Data struct {
 		Search struct {
-			Edges []struct {
+			RepositoryCount int `json:"repositoryCount"`
+			Edges           []struct {
 				Cursor string           `json:"cursor"`
 				Node   model.Repository `json:"node"`
 			} `json:"edges"`
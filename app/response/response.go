@@ -1,11 +1,9 @@
 package response
 
 import (
-	"fmt"
-	"github.com/memochou1993/gh-rankings/logger"
-	"github.com/memochou1993/gh-rankings/util"
-	"log"
-	"strconv"
+	"encoding/json"
+	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/query"
 	"time"
 )
 
@@ -23,30 +21,129 @@ type RateLimit struct {
 	Used      int64  `json:"used,omitempty"`
 }
 
-func (r RateLimit) Throttle(collecting int64) {
-	logger.Debug(fmt.Sprintf("Rate Limit: %s", strconv.Quote(util.ParseStruct(r, " "))))
-	resetAt, err := time.Parse(time.RFC3339, r.ResetAt)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	remainingTime := resetAt.Add(time.Second).Sub(time.Now().UTC())
-	time.Sleep(time.Duration(remainingTime.Milliseconds()/r.Remaining*collecting) * time.Millisecond)
-	if r.Remaining > collecting {
-		return
-	}
-	logger.Warning("Take a break...")
-	time.Sleep(remainingTime)
-}
-
 type Error struct {
 	Type      string `json:"type"`
 	Locations []struct {
 		Line   int64 `json:"line"`
 		Column int64 `json:"column"`
 	} `json:"locations"`
-	Message string `json:"message"`
+	Path    []interface{} `json:"path"`
+	Message string        `json:"message"`
 }
 
 func (e Error) Error() string {
 	return e.Message
 }
+
+// Alias returns the top-level field the error belongs to, such as the alias of an owner in a batch query.
+func (e Error) Alias() string {
+	if len(e.Path) == 0 {
+		return ""
+	}
+	alias, _ := e.Path[0].(string)
+	return alias
+}
+
+type Owners struct {
+	Data    map[string]json.RawMessage `json:"data"`
+	Errors  []Error                    `json:"errors"`
+	Message string                     `json:"message"`
+}
+
+type Resources struct {
+	Login string `json:"login"`
+	Gists struct {
+		Edges []struct {
+			Cursor string     `json:"cursor"`
+			Node   query.Gist `json:"node"`
+		} `json:"edges"`
+		PageInfo `json:"pageInfo"`
+	} `json:"gists"`
+	Repositories struct {
+		Edges []struct {
+			Cursor string           `json:"cursor"`
+			Node   model.Repository `json:"node"`
+		} `json:"edges"`
+		PageInfo `json:"pageInfo"`
+	} `json:"repositories"`
+	ContributedRepositories struct {
+		Edges []struct {
+			Cursor string           `json:"cursor"`
+			Node   model.Repository `json:"node"`
+		} `json:"edges"`
+		PageInfo `json:"pageInfo"`
+	} `json:"contributedRepositories"`
+	ContributionsCollection *ContributionsCollection `json:"contributionsCollection"`
+}
+
+type ContributionsByRepository []struct {
+	Contributions query.Items `json:"contributions"`
+	Repository    struct {
+		NameWithOwner   string `json:"nameWithOwner"`
+		PrimaryLanguage struct {
+			Name string `json:"name"`
+		} `json:"primaryLanguage"`
+	} `json:"repository"`
+}
+
+type ContributionsCollection struct {
+	StartedAt                                  time.Time                 `json:"startedAt"`
+	EndedAt                                    time.Time                 `json:"endedAt"`
+	TotalCommitContributions                   int                       `json:"totalCommitContributions"`
+	TotalIssueContributions                    int                       `json:"totalIssueContributions"`
+	TotalPullRequestContributions              int                       `json:"totalPullRequestContributions"`
+	TotalPullRequestReviewContributions        int                       `json:"totalPullRequestReviewContributions"`
+	RestrictedContributionsCount               int                       `json:"restrictedContributionsCount"`
+	CommitContributionsByRepository            ContributionsByRepository `json:"commitContributionsByRepository"`
+	IssueContributionsByRepository             ContributionsByRepository `json:"issueContributionsByRepository"`
+	PullRequestContributionsByRepository       ContributionsByRepository `json:"pullRequestContributionsByRepository"`
+	PullRequestReviewContributionsByRepository ContributionsByRepository `json:"pullRequestReviewContributionsByRepository"`
+}
+
+func (c *ContributionsCollection) Contributions() *model.Contributions {
+	contributions := &model.Contributions{
+		StartedAt:    &c.StartedAt,
+		EndedAt:      &c.EndedAt,
+		Commits:      &query.Items{TotalCount: c.TotalCommitContributions},
+		Issues:       &query.Items{TotalCount: c.TotalIssueContributions},
+		PullRequests: &query.Items{TotalCount: c.TotalPullRequestContributions},
+		Reviews:      &query.Items{TotalCount: c.TotalPullRequestReviewContributions},
+		Restricted:   &query.Items{TotalCount: c.RestrictedContributionsCount},
+	}
+
+	index := map[string]int{}
+	add := func(byRepository ContributionsByRepository, set func(r *model.ContributedRepository, items *query.Items)) {
+		for _, item := range byRepository {
+			name := item.Repository.NameWithOwner
+			i, ok := index[name]
+			if !ok {
+				r := model.ContributedRepository{
+					NameWithOwner: name,
+					Commits:       &query.Items{},
+					Issues:        &query.Items{},
+					PullRequests:  &query.Items{},
+					Reviews:       &query.Items{},
+				}
+				r.PrimaryLanguage.Name = item.Repository.PrimaryLanguage.Name
+				i = len(contributions.Repositories)
+				index[name] = i
+				contributions.Repositories = append(contributions.Repositories, r)
+			}
+			set(&contributions.Repositories[i], &query.Items{TotalCount: item.Contributions.TotalCount})
+		}
+	}
+	add(c.CommitContributionsByRepository, func(r *model.ContributedRepository, items *query.Items) {
+		r.Commits = items
+	})
+	add(c.IssueContributionsByRepository, func(r *model.ContributedRepository, items *query.Items) {
+		r.Issues = items
+	})
+	add(c.PullRequestContributionsByRepository, func(r *model.ContributedRepository, items *query.Items) {
+		r.PullRequests = items
+	})
+	add(c.PullRequestReviewContributionsByRepository, func(r *model.ContributedRepository, items *query.Items) {
+		r.Reviews = items
+	})
+
+	return contributions
+}
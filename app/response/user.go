@@ -9,7 +9,8 @@ import (
 type User struct {
 	Data struct {
 		Search struct {
-			Edges []struct {
+			UserCount int `json:"userCount"`
+			Edges     []struct {
 				Cursor string     `json:"cursor"`
 				Node   model.User `json:"node"`
 			} `json:"edges"`
@@ -36,6 +37,13 @@ type User struct {
 				} `json:"edges"`
 				PageInfo `json:"pageInfo"`
 			} `json:"repositories"`
+			ContributedRepositories struct {
+				Edges []struct {
+					Cursor string           `json:"cursor"`
+					Node   model.Repository `json:"node"`
+				} `json:"edges"`
+				PageInfo `json:"pageInfo"`
+			} `json:"contributedRepositories"`
 		} `json:"owner"`
 		RateLimit `json:"rateLimit"`
 	} `json:"data"`
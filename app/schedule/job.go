@@ -0,0 +1,59 @@
+package schedule
+
+import (
+	"time"
+)
+
+type Job struct {
+	Command  string
+	Then     string
+	Schedule *Schedule
+	At       time.Time
+}
+
+// Start schedules the first run of the job after it last started, which is now if it never ran or missed its run while the worker was down, in which case the missed time is returned.
+func (j *Job) Start(last time.Time, now time.Time, jitter time.Duration) (missed time.Time) {
+	if last.IsZero() {
+		j.At = now
+		return
+	}
+	next := j.Schedule.Next(last)
+	if next.Before(now) {
+		j.At = now
+		return next
+	}
+	j.At = Jitter(next, jitter)
+	return
+}
+
+// Reschedule schedules the next run of the job after it has fired.
+func (j *Job) Reschedule(now time.Time, jitter time.Duration) {
+	j.At = Jitter(j.Schedule.Next(now), jitter)
+}
+
+type Jobs []*Job
+
+// Next returns the job which is due first.
+func (jobs Jobs) Next() *Job {
+	next := jobs[0]
+	for _, job := range jobs[1:] {
+		if job.At.Before(next.At) {
+			next = job
+		}
+	}
+	return next
+}
+
+// Commands returns the commands to run when a command is due or triggered, including the command its job is followed by. Scheduled commands are skipped while paused.
+func (jobs Jobs) Commands(command string, scheduled bool, paused bool) []string {
+	if scheduled && paused {
+		return nil
+	}
+	commands := []string{command}
+	for _, job := range jobs {
+		if job.Command == command && job.Then != "" {
+			commands = append(commands, job.Then)
+		}
+	}
+	return commands
+}
@@ -0,0 +1,28 @@
+package schedule
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type Schedule struct {
+	cron.Schedule
+}
+
+func Parse(spec string) (*Schedule, error) {
+	s, err := cron.ParseStandard(strings.TrimSpace(spec))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return &Schedule{s}, nil
+}
+
+func Jitter(t time.Time, max time.Duration) time.Time {
+	if max <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(rand.Int63n(int64(max))))
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/util"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SourceGraphQL = "graphql"
+	SourceFixture = "fixture"
+)
+
+type Source interface {
+	Fetch(ctx context.Context, q string, v interface{}) error
+}
+
+type GraphQLSource struct {
+	RecordPath string
+	Archive    *Archive
+}
+
+func (s *GraphQLSource) Fetch(ctx context.Context, q string, v interface{}) error {
+	b, err := fetch(ctx, q)
+	if err != nil {
+		return err
+	}
+	if s.RecordPath != "" {
+		if err := record(s.RecordPath, q, b); err != nil {
+			return err
+		}
+	}
+	if s.Archive != nil {
+		if err := s.Archive.Write(q, b); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, v)
+}
+
+type FixtureSource struct {
+	Path string
+}
+
+func (s *FixtureSource) Fetch(ctx context.Context, q string, v interface{}) error {
+	b, err := ioutil.ReadFile(filepath.Join(s.Path, FixtureName(q)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func FixtureName(q string) string {
+	return fmt.Sprintf("%x.json", sha1.Sum([]byte(q)))
+}
+
+func NewGraphQLSource() *GraphQLSource {
+	s := &GraphQLSource{}
+	if viper.GetBool("FIXTURE_RECORD") {
+		s.RecordPath = fixturePath()
+	}
+	if viper.GetBool("ARCHIVE_RECORD") {
+		s.Archive = NewArchive(ArchivePath())
+	}
+	return s
+}
+
+func NewFixtureSource(path string) *FixtureSource {
+	return &FixtureSource{
+		Path: path,
+	}
+}
+
+func NewSource() Source {
+	switch viper.GetString("API_SOURCE") {
+	case SourceFixture:
+		return NewFixtureSource(fixturePath())
+	default:
+		return NewGraphQLSource()
+	}
+}
+
+func fixturePath() string {
+	if path := viper.GetString("FIXTURE_PATH"); path != "" {
+		return path
+	}
+	return fmt.Sprintf("%s/storage/fixtures", util.Root())
+}
+
+func record(path string, q string, b []byte) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(path, FixtureName(q)), b, 0644)
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/logger"
+	"github.com/spf13/viper"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimit = 5000
+)
+
+var (
+	tokens     *TokenPool
+	tokensOnce sync.Once
+)
+
+type Token struct {
+	Value     string
+	Limit     int64
+	Remaining int64
+	ResetAt   time.Time
+}
+
+func (t *Token) headroom(now time.Time) int64 {
+	if now.After(t.ResetAt) {
+		return t.Limit
+	}
+	return t.Remaining
+}
+
+type TokenPool struct {
+	mu     sync.Mutex
+	tokens []*Token
+}
+
+func (p *TokenPool) Acquire(ctx context.Context) (*Token, error) {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		var token *Token
+		for _, t := range p.tokens {
+			if t.headroom(now) > 0 && (token == nil || t.headroom(now) > token.headroom(now)) {
+				token = t
+			}
+		}
+		if token != nil {
+			if now.After(token.ResetAt) {
+				token.Remaining = token.Limit
+				token.ResetAt = now.Add(time.Hour)
+			}
+			token.Remaining--
+			p.mu.Unlock()
+			return token, nil
+		}
+		resetAt := p.resetAt()
+		p.mu.Unlock()
+
+		logger.Warning(fmt.Sprintf("All %d tokens exhausted, waiting until %s...", len(p.tokens), resetAt.Format(time.RFC3339)))
+		select {
+		case <-time.After(time.Until(resetAt) + time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *TokenPool) Update(token *Token, limit int64, remaining int64, resetAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if limit > 0 {
+		token.Limit = limit
+	}
+	token.Remaining = remaining
+	token.ResetAt = resetAt
+}
+
+func (p *TokenPool) Exhausted() (resetAt time.Time, exhausted bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, t := range p.tokens {
+		if t.headroom(now) > 0 {
+			return time.Time{}, false
+		}
+	}
+	return p.resetAt(), true
+}
+
+func (p *TokenPool) resetAt() time.Time {
+	resetAt := p.tokens[0].ResetAt
+	for _, t := range p.tokens {
+		if t.ResetAt.Before(resetAt) {
+			resetAt = t.ResetAt
+		}
+	}
+	return resetAt
+}
+
+func (p *TokenPool) Len() int {
+	return len(p.tokens)
+}
+
+func NewTokenPool(values []string) *TokenPool {
+	p := &TokenPool{}
+	for _, value := range values {
+		p.tokens = append(p.tokens, &Token{
+			Value:     value,
+			Limit:     defaultRateLimit,
+			Remaining: defaultRateLimit,
+		})
+	}
+	return p
+}
+
+func Tokens() *TokenPool {
+	tokensOnce.Do(func() {
+		var values []string
+		for _, value := range viper.GetStringSlice("API_TOKENS") {
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+		if len(values) == 0 {
+			values = append(values, viper.GetString("API_TOKEN"))
+		}
+		tokens = NewTokenPool(values)
+	})
+	return tokens
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/memochou1993/gh-rankings/app"
@@ -11,7 +12,6 @@ import (
 	"github.com/memochou1993/gh-rankings/app/resource"
 	"github.com/memochou1993/gh-rankings/app/response"
 	"github.com/memochou1993/gh-rankings/logger"
-	"strconv"
 	"time"
 )
 
@@ -25,177 +25,437 @@ type Organization struct {
 	RepositoryQuery   *query.Query
 }
 
-func (o *Organization) Init() {
-	o.Worker.load(timestampOrganization)
-}
-
-func (o *Organization) Collect() error {
+func (o *Organization) Collect(ctx context.Context) error {
 	logger.Info("Collecting organizations...")
-	o.From = time.Date(2007, time.October, 1, 0, 0, 0, 0, time.UTC)
-	o.To = time.Now()
+	o.From = o.Worker.Since
+	o.To = o.Worker.now()
 
 	if o.Worker.Timestamp.IsZero() {
 		last := model.Organization{}
-		if o.OrganizationModel.Model.Last(&last); last.ID() != "" {
+		if err := o.OrganizationModel.Model.Last(ctx, &last); err != nil {
+			return err
+		}
+		if last.ID() != "" {
 			o.From = last.CreatedAt.AddDate(0, 0, -7).Truncate(24 * time.Hour)
 		}
 	}
 
-	if err := o.Prepare(); err != nil {
+	if err := o.Prepare(ctx); err != nil {
+		return err
+	}
+
+	if err := o.Worker.resume(ctx); err != nil {
+		return err
+	}
+	if o.Checkpoint.ID() != "" {
+		o.From = o.Checkpoint.From
+		logger.Info(fmt.Sprintf("Resuming organizations from %s...", o.From.Format(time.RFC3339)))
+	}
+
+	if err := o.Travel(ctx); err != nil {
 		return err
 	}
 
-	return o.Travel()
+	return o.Worker.finish(ctx)
 }
 
-func (o *Organization) Prepare() error {
+func (o *Organization) Prepare(ctx context.Context) error {
 	for _, organization := range resource.SpecifiedOrganizations {
-		var organizations []model.Organization
-		o.SearchQuery.SearchArguments.SetQuery(query.SearchSpecifiedOrganization(organization.Login))
-		logger.Debug(fmt.Sprintf("Organization Query: %s", o.SearchQuery.SearchArguments.Query))
-		if err := o.Fetch(&organizations); err != nil {
+		if err := o.Pull(ctx, organization.Login); errors.Is(err, ErrSkipped) {
+			logger.Warning(fmt.Sprintf("Skipped organization %s: %s", organization.Login, err.Error()))
+		} else if err != nil {
 			return err
 		}
+	}
 
-		if res := o.OrganizationModel.Store(organizations); res != nil {
-			if res.ModifiedCount > 0 {
-				logger.Success(fmt.Sprintf("Updated %d organizations!", res.ModifiedCount))
-			}
-			if res.UpsertedCount > 0 {
-				logger.Success(fmt.Sprintf("Inserted %d organizations!", res.UpsertedCount))
-			}
+	return nil
+}
+
+func (o *Organization) Pull(ctx context.Context, login string) error {
+	var organizations []model.Organization
+	o.SearchQuery.SearchArguments.SetQuery(query.SearchSpecifiedOrganization(login))
+	logger.Debug(fmt.Sprintf("Organization Query: %s", o.SearchQuery.SearchArguments.Query))
+	if err := o.Fetch(ctx, &organizations); err != nil {
+		return err
+	}
+
+	if err := o.store(ctx, organizations); err != nil {
+		return err
+	}
+	if err := o.Update(ctx, organizations); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *Organization) Refresh(ctx context.Context) error {
+	logger.Info("Refreshing organizations...")
+	before := time.Now()
+	for limit := refreshLimit(); limit > 0; limit -= refreshBatch {
+		size := refreshBatch
+		if limit < size {
+			size = limit
 		}
-		for _, organization := range organizations {
-			if err := o.Update(organization); err != nil {
-				return err
-			}
+		stale, err := o.OrganizationModel.Stale(ctx, before, int64(size))
+		if err != nil {
+			return err
+		}
+		if len(stale) == 0 {
+			break
+		}
+		var logins []string
+		for _, organization := range stale {
+			logins = append(logins, organization.ID())
+		}
+		var organizations []model.Organization
+		if err := o.FetchByNodeID(ctx, stale, &organizations); err != nil {
+			return err
+		}
+		if err := o.store(ctx, organizations); err != nil {
+			return err
 		}
+		if err := o.Update(ctx, organizations); err != nil {
+			return err
+		}
+		if err := o.OrganizationModel.Touch(ctx, logins); err != nil {
+			return err
+		}
+		logger.Success(fmt.Sprintf("Refreshed %d of %d organizations!", len(organizations), len(logins)))
 	}
 
 	return nil
 }
 
-func (o *Organization) Travel() error {
+func (o *Organization) Travel(ctx context.Context) error {
 	if o.From.After(o.To) {
 		return nil
 	}
 
-	var organizations []model.Organization
-	o.SearchQuery.SearchArguments.SetQuery(query.SearchOrganizations(o.From, o.From.AddDate(0, 0, 7)))
-	logger.Debug(fmt.Sprintf("Organization Query: %s", o.SearchQuery.SearchArguments.Query))
-	if err := o.Fetch(&organizations); err != nil {
-		return err
+	if !o.Checkpoint.From.Equal(o.From) {
+		o.Worker.advance(o.From)
 	}
 
-	if res := o.OrganizationModel.Store(organizations); res != nil {
-		if res.ModifiedCount > 0 {
-			logger.Success(fmt.Sprintf("Updated %d organizations!", res.ModifiedCount))
+	if o.Checkpoint.Phase == model.PhaseSearch {
+		o.SearchQuery.SearchArguments.SetQuery(query.SearchOrganizations(o.Checkpoint.From, o.Checkpoint.To))
+		if o.Checkpoint.Cursor != "" {
+			o.SearchQuery.SearchArguments.After = o.Checkpoint.Cursor
 		}
-		if res.UpsertedCount > 0 {
-			logger.Success(fmt.Sprintf("Inserted %d organizations!", res.UpsertedCount))
+		logger.Debug(fmt.Sprintf("Organization Query: %s", o.SearchQuery.SearchArguments.Query))
+		if err := o.Search(ctx); err != nil {
+			return err
 		}
 	}
-	for _, organization := range organizations {
-		if err := o.Update(organization); err != nil {
+
+	pending := o.Checkpoint.Pending()
+	for start := 0; start < len(pending); start += resourceBatch {
+		end := start + resourceBatch
+		if end > len(pending) {
+			end = len(pending)
+		}
+		o.Checkpoint.Phase = model.PhaseResources
+		o.Checkpoint.Owner = pending[start]
+		if err := o.Worker.checkpoint(ctx); err != nil {
 			return err
 		}
+		var organizations []model.Organization
+		for _, login := range pending[start:end] {
+			organizations = append(organizations, model.Organization{Login: login})
+		}
+		if err := o.UpdateResources(ctx, organizations); err != nil {
+			return err
+		}
+	}
+	o.From = o.Checkpoint.To
+
+	return o.Travel(ctx)
+}
+
+func (o *Organization) Search(ctx context.Context) error {
+	var organizations []model.Organization
+	res := response.Organization{}
+	if err := o.query(ctx, *o.SearchQuery, &res); err != nil {
+		return err
+	}
+	if o.Checkpoint.Cursor == "" && o.Worker.split(res.Data.Search.UserCount) {
+		o.SearchQuery.SearchArguments.SetQuery(query.SearchOrganizations(o.Checkpoint.From, o.Checkpoint.To))
+		logger.Debug(fmt.Sprintf("Organization Query: %s", o.SearchQuery.SearchArguments.Query))
+		return o.Search(ctx)
+	}
+	for _, edge := range res.Data.Search.Edges {
+		organizations = append(organizations, edge.Node)
+		o.Checkpoint.Owners = append(o.Checkpoint.Owners, edge.Node.ID())
+	}
+	if err := o.store(ctx, organizations); err != nil {
+		return err
+	}
+	if !res.Data.Search.PageInfo.HasNextPage {
+		o.SearchQuery.SearchArguments.After = ""
+		o.Checkpoint.Phase = model.PhaseResources
+		o.Checkpoint.Cursor = ""
+		return o.Worker.checkpoint(ctx)
+	}
+	o.SearchQuery.SearchArguments.After = res.Data.Search.PageInfo.EndCursor
+	o.Checkpoint.Cursor = res.Data.Search.PageInfo.EndCursor
+	if err := o.Worker.checkpoint(ctx); err != nil {
+		return err
 	}
-	o.From = o.From.AddDate(0, 0, 7)
 
-	return o.Travel()
+	return o.Search(ctx)
 }
 
-func (o *Organization) Fetch(organizations *[]model.Organization) error {
+func (o *Organization) Fetch(ctx context.Context, organizations *[]model.Organization) error {
 	res := response.Organization{}
-	if err := o.query(*o.SearchQuery, &res); err != nil {
+	if err := o.query(ctx, *o.SearchQuery, &res); err != nil {
 		return err
 	}
 	for _, edge := range res.Data.Search.Edges {
 		*organizations = append(*organizations, edge.Node)
 	}
-	res.Data.RateLimit.Throttle(collecting)
 	if !res.Data.Search.PageInfo.HasNextPage {
 		o.SearchQuery.SearchArguments.After = ""
 		return nil
 	}
-	o.SearchQuery.SearchArguments.After = strconv.Quote(res.Data.Search.PageInfo.EndCursor)
+	o.SearchQuery.SearchArguments.After = res.Data.Search.PageInfo.EndCursor
 
-	return o.Fetch(organizations)
+	return o.Fetch(ctx, organizations)
 }
 
-func (o *Organization) Update(organization model.Organization) error {
-	o.RepositoryQuery.Type = app.TypeOrganization
-	o.RepositoryQuery.OwnerArguments.Login = strconv.Quote(organization.ID())
-	if err := o.UpdateRepositories(organization); err != nil {
+func (o *Organization) FetchByNodeID(ctx context.Context, owners []model.Organization, organizations *[]model.Organization) error {
+	var logins []string
+	var nodeIDs []string
+	for _, owner := range owners {
+		logins = append(logins, owner.ID())
+		nodeIDs = append(nodeIDs, owner.NodeID)
+	}
+	q := query.OwnersByLogin(logins)
+	q.NodeIDs = nodeIDs
+	data, errs, err := o.Worker.batch(ctx, q)
+	if errors.Is(err, ErrSkipped) {
+		logger.Warning(fmt.Sprintf("Skipped %d organizations: %s", len(logins), err.Error()))
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	var gone []string
+	for i, login := range logins {
+		if err, ok := errs[query.Alias(i)]; ok && err.Type != errorTypeNotFound {
+			logger.Warning(fmt.Sprintf("Skipped organization %s: %s", login, err.Error()))
+			continue
+		}
+		raw, ok := data[query.Alias(i)]
+		if !ok || string(raw) == "null" {
+			gone = append(gone, login)
+			continue
+		}
+		organization := model.Organization{}
+		if err := json.Unmarshal(raw, &organization); err != nil {
+			return err
+		}
+		if organization.ID() != "" {
+			*organizations = append(*organizations, organization)
+		}
+	}
+
+	return o.gone(ctx, gone)
+}
+
+func (o *Organization) Update(ctx context.Context, organizations []model.Organization) error {
+	for start := 0; start < len(organizations); start += resourceBatch {
+		end := start + resourceBatch
+		if end > len(organizations) {
+			end = len(organizations)
+		}
+		if err := o.Worker.wait(ctx); err != nil {
+			return err
+		}
+		if err := o.UpdateResources(ctx, organizations[start:end]); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (o *Organization) UpdateRepositories(organization model.Organization) error {
-	var repositories []model.Repository
-	if err := o.FetchRepositories(&repositories); err != nil {
+func (o *Organization) UpdateResources(ctx context.Context, organizations []model.Organization) error {
+	var logins []string
+	for _, organization := range organizations {
+		logins = append(logins, organization.ID())
+	}
+	nodeIDs, err := o.OrganizationModel.NodeIDs(ctx, logins)
+	if err != nil {
+		return err
+	}
+	q := query.OwnerResources(app.TypeOrganization, logins)
+	for _, login := range logins {
+		q.NodeIDs = append(q.NodeIDs, nodeIDs[login])
+	}
+	data, errs, err := o.Worker.batch(ctx, q)
+	if errors.Is(err, ErrSkipped) {
+		logger.Warning(fmt.Sprintf("Skipped resources of %d organizations: %s", len(organizations), err.Error()))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var gone []string
+	for i, organization := range organizations {
+		if err, ok := errs[query.Alias(i)]; ok && err.Type != errorTypeNotFound {
+			logger.Warning(fmt.Sprintf("Skipped resources of organization %s: %s", organization.ID(), err.Error()))
+			continue
+		}
+		raw, ok := data[query.Alias(i)]
+		if !ok || string(raw) == "null" {
+			gone = append(gone, organization.ID())
+			continue
+		}
+		res := response.Resources{}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		login := organization.ID()
+		if res.Login != "" {
+			login = res.Login
+		}
+
+		var repositories []model.Repository
+		for _, edge := range res.Repositories.Edges {
+			repositories = append(repositories, edge.Node)
+		}
+		if res.Repositories.PageInfo.HasNextPage {
+			o.RepositoryQuery.OwnerArguments.Login = login
+			o.RepositoryQuery.RepositoriesArguments.After = res.Repositories.PageInfo.EndCursor
+			if err := o.FetchRepositories(ctx, &repositories); errors.Is(err, ErrSkipped) {
+				logger.Warning(fmt.Sprintf("Skipped remaining repositories of organization %s: %s", organization.ID(), err.Error()))
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := o.OrganizationModel.UpdateRepositories(ctx, organization, repositories); err != nil {
+			return err
+		}
+	}
+	if err := o.gone(ctx, gone); err != nil {
 		return err
 	}
-	o.OrganizationModel.UpdateRepositories(organization, repositories)
-	logger.Success(fmt.Sprintf("Updated %d %s repositories!", len(repositories), app.TypeOrganization))
+	logger.Success(fmt.Sprintf("Updated repositories of %d organizations!", len(organizations)))
 	return nil
 }
 
-func (o *Organization) FetchRepositories(repositories *[]model.Repository) error {
+func (o *Organization) FetchRepositories(ctx context.Context, repositories *[]model.Repository) error {
 	res := response.Organization{}
-	if err := o.query(*o.RepositoryQuery, &res); err != nil {
+	if err := o.query(ctx, *o.RepositoryQuery, &res); err != nil {
 		return err
 	}
 	for _, edge := range res.Data.Organization.Repositories.Edges {
 		*repositories = append(*repositories, edge.Node)
 	}
-	res.Data.RateLimit.Throttle(collecting)
 	if !res.Data.Organization.Repositories.PageInfo.HasNextPage {
 		o.RepositoryQuery.RepositoriesArguments.After = ""
 		return nil
 	}
-	o.RepositoryQuery.RepositoriesArguments.After = strconv.Quote(res.Data.Organization.Repositories.PageInfo.EndCursor)
+	o.RepositoryQuery.RepositoriesArguments.After = res.Data.Organization.Repositories.PageInfo.EndCursor
 
-	return o.FetchRepositories(repositories)
+	return o.FetchRepositories(ctx, repositories)
 }
 
-func (o *Organization) Rank() {
+func (o *Organization) gone(ctx context.Context, logins []string) error {
+	if len(logins) == 0 {
+		return nil
+	}
+	if err := o.OrganizationModel.MarkGone(ctx, logins); err != nil {
+		return err
+	}
+	logger.Warning(fmt.Sprintf("Marked %d organizations as gone!", len(logins)))
+	return nil
+}
+
+func (o *Organization) store(ctx context.Context, organizations []model.Organization) error {
+	o.Worker.processed(len(organizations))
+	res, err := o.OrganizationModel.Store(ctx, organizations)
+	if err != nil {
+		return err
+	}
+	if res != nil {
+		if res.ModifiedCount > 0 {
+			logger.Success(fmt.Sprintf("Updated %d organizations!", res.ModifiedCount))
+		}
+		if res.UpsertedCount > 0 {
+			logger.Success(fmt.Sprintf("Inserted %d organizations!", res.UpsertedCount))
+		}
+	}
+	return nil
+}
+
+func (o *Organization) Rank(ctx context.Context) error {
 	logger.Info("Executing organization rank pipelines...")
+	if err := o.Worker.cleanup(ctx); err != nil {
+		return err
+	}
 	pipelines := pipeline.RankOrganization()
-	timestamp := time.Now()
+	timestamp, err := o.Worker.stage(ctx)
+	if err != nil {
+		return err
+	}
 	for i, p := range pipelines {
-		o.RankModel.Store(o.OrganizationModel, *p, timestamp)
+		if err := o.Worker.wait(ctx); err != nil {
+			o.discard(timestamp)
+			return err
+		}
+		if err := o.RankModel.Store(ctx, o.OrganizationModel, *p, timestamp); err != nil {
+			o.discard(timestamp)
+			return err
+		}
 		if (i+1)%10 == 0 || (i+1) == len(pipelines) {
 			logger.Success(fmt.Sprintf("Executed %d of %d organization rank pipelines!", i+1, len(pipelines)))
 		}
 	}
-	o.Worker.save(timestampOrganization, timestamp)
-	o.RankModel.Delete(timestamp, app.TypeOrganization)
-}
-
-func (o *Organization) query(q query.Query, res *response.Organization) (err error) {
-	err = app.Fetch(context.Background(), fmt.Sprint(q), res)
-	if res.Message != "" {
-		err = errors.New(res.Message)
-		res.Message = ""
+	if err := ctx.Err(); err != nil {
+		o.discard(timestamp)
+		return err
 	}
-	for _, err = range res.Errors {
+	if err := o.Worker.publish(ctx, timestamp); err != nil {
+		o.discard(timestamp)
 		return err
 	}
-	if err != nil {
+	return o.RankModel.Prune(ctx, app.TypeOrganization, o.Worker.run.Timestamps())
+}
+
+func (o *Organization) pipelines() []*pipeline.Pipeline {
+	return pipeline.RankOrganization()
+}
+
+func (o *Organization) collection() model.Interface {
+	return o.OrganizationModel
+}
+
+func (o *Organization) discard(timestamp time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := o.RankModel.Discard(ctx, timestamp, app.TypeOrganization); err != nil {
 		logger.Error(err.Error())
-		logger.Warning("Retrying...")
-		time.Sleep(10 * time.Second)
-		return o.query(q, res)
+		return
 	}
-	return
+	logger.Warning(fmt.Sprintf("Discarded organization ranks created at %s!", timestamp.Format(time.RFC3339)))
+}
+
+func (o *Organization) query(ctx context.Context, q query.Query, res *response.Organization) error {
+	return o.Worker.retry(ctx, func() error {
+		*res = response.Organization{}
+		if err := o.source().Fetch(ctx, fmt.Sprint(q), res); err != nil {
+			return err
+		}
+		if res.Message != "" {
+			return errors.New(res.Message)
+		}
+		return graphQLError(res.Errors)
+	})
 }
 
 func NewOrganizationWorker() *Organization {
 	return &Organization{
-		Worker:            &Worker{},
+		Worker:            newWorker(app.TypeOrganization),
 		OrganizationModel: model.NewOrganizationModel(),
 		RankModel:         model.NewRankModel(),
 		SearchQuery:       query.Owners(),
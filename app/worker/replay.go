@@ -0,0 +1,268 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/query"
+	"github.com/memochou1993/gh-rankings/app/response"
+	"github.com/memochou1993/gh-rankings/logger"
+)
+
+const (
+	typenameUser         = "User"
+	typenameOrganization = "Organization"
+)
+
+type replay struct {
+	userModel                *model.UserModel
+	organizationModel        *model.OrganizationModel
+	repositoryModel          *model.RepositoryModel
+	gists                    map[string][]query.Gist
+	userRepositories         map[string][]model.Repository
+	organizationRepositories map[string][]model.Repository
+	contributedRepositories  map[string][]model.Repository
+	entries                  int
+}
+
+func Replay(ctx context.Context, path string) error {
+	r := &replay{
+		userModel:                UserWorker.UserModel,
+		organizationModel:        OrganizationWorker.OrganizationModel,
+		repositoryModel:          RepositoryWorker.RepositoryModel,
+		gists:                    map[string][]query.Gist{},
+		userRepositories:         map[string][]model.Repository{},
+		organizationRepositories: map[string][]model.Repository{},
+		contributedRepositories:  map[string][]model.Repository{},
+	}
+	logger.Info(fmt.Sprintf("Replaying responses from %s...", path))
+	err := app.NewArchive(path).Each(func(entry *app.Entry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.entries++
+		return r.apply(ctx, entry)
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.flushUsers(ctx); err != nil {
+		return err
+	}
+	if err := r.flushOrganizations(ctx); err != nil {
+		return err
+	}
+	logger.Success(fmt.Sprintf("Replayed %d responses!", r.entries))
+	return nil
+}
+
+func (r *replay) apply(ctx context.Context, entry *app.Entry) error {
+	switch name := entry.Operation(); name {
+	case "Owners":
+		return r.owners(ctx, entry)
+	case "OwnersByLogin":
+		return r.ownersByLogin(ctx, entry)
+	case "Repositories":
+		return r.repositories(ctx, entry)
+	case "UserResources":
+		if err := r.flushUsers(ctx); err != nil {
+			return err
+		}
+		return r.resources(ctx, entry, app.TypeUser)
+	case "OrganizationResources":
+		if err := r.flushOrganizations(ctx); err != nil {
+			return err
+		}
+		return r.resources(ctx, entry, app.TypeOrganization)
+	case "OwnerGists", "OwnerRepositories", "OwnerContributedRepositories":
+		return r.page(entry)
+	default:
+		logger.Warning(fmt.Sprintf("Skipping unknown operation %q...", name))
+	}
+	return nil
+}
+
+func (r *replay) owners(ctx context.Context, entry *app.Entry) error {
+	res := struct {
+		Data struct {
+			Search struct {
+				Edges []struct {
+					Node json.RawMessage `json:"node"`
+				} `json:"edges"`
+			} `json:"search"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(entry.Response, &res); err != nil {
+		return err
+	}
+	var nodes []json.RawMessage
+	for _, edge := range res.Data.Search.Edges {
+		nodes = append(nodes, edge.Node)
+	}
+	return r.store(ctx, entry, nodes)
+}
+
+func (r *replay) ownersByLogin(ctx context.Context, entry *app.Entry) error {
+	res := response.Owners{}
+	if err := json.Unmarshal(entry.Response, &res); err != nil {
+		return err
+	}
+	var nodes []json.RawMessage
+	for alias, raw := range res.Data {
+		if alias == "rateLimit" || string(raw) == "null" {
+			continue
+		}
+		nodes = append(nodes, raw)
+	}
+	return r.store(ctx, entry, nodes)
+}
+
+func (r *replay) store(ctx context.Context, entry *app.Entry, nodes []json.RawMessage) error {
+	var users []model.User
+	var organizations []model.Organization
+	for _, raw := range nodes {
+		node := struct {
+			Typename string `json:"__typename"`
+		}{}
+		if err := json.Unmarshal(raw, &node); err != nil {
+			return err
+		}
+		switch node.Typename {
+		case typenameUser:
+			user := model.User{FetchedAt: &entry.FetchedAt}
+			if err := json.Unmarshal(raw, &user); err != nil {
+				return err
+			}
+			users = append(users, user)
+		case typenameOrganization:
+			organization := model.Organization{FetchedAt: &entry.FetchedAt}
+			if err := json.Unmarshal(raw, &organization); err != nil {
+				return err
+			}
+			organizations = append(organizations, organization)
+		}
+	}
+	if _, err := r.userModel.Store(ctx, users); err != nil {
+		return err
+	}
+	if _, err := r.organizationModel.Store(ctx, organizations); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *replay) repositories(ctx context.Context, entry *app.Entry) error {
+	res := response.Repository{}
+	if err := json.Unmarshal(entry.Response, &res); err != nil {
+		return err
+	}
+	var repositories []model.Repository
+	for _, edge := range res.Data.Search.Edges {
+		repositories = append(repositories, edge.Node)
+	}
+	_, err := r.repositoryModel.Store(ctx, repositories)
+	return err
+}
+
+func (r *replay) resources(ctx context.Context, entry *app.Entry, ownerType string) error {
+	res := response.Owners{}
+	if err := json.Unmarshal(entry.Response, &res); err != nil {
+		return err
+	}
+	for alias, raw := range res.Data {
+		if alias == "rateLimit" || string(raw) == "null" {
+			continue
+		}
+		resources := response.Resources{}
+		if err := json.Unmarshal(raw, &resources); err != nil {
+			return err
+		}
+		var repositories []model.Repository
+		for _, edge := range resources.Repositories.Edges {
+			repositories = append(repositories, edge.Node)
+		}
+		if ownerType == app.TypeOrganization {
+			r.organizationRepositories[resources.Login] = repositories
+			continue
+		}
+		if resources.ContributionsCollection != nil {
+			user := model.User{Login: resources.Login}
+			if err := r.userModel.UpdateContributions(ctx, user, resources.ContributionsCollection.Contributions()); err != nil {
+				return err
+			}
+		}
+		var gists []query.Gist
+		for _, edge := range resources.Gists.Edges {
+			gists = append(gists, edge.Node)
+		}
+		var contributedRepositories []model.Repository
+		for _, edge := range resources.ContributedRepositories.Edges {
+			contributedRepositories = append(contributedRepositories, edge.Node)
+		}
+		r.gists[resources.Login] = gists
+		r.userRepositories[resources.Login] = repositories
+		r.contributedRepositories[resources.Login] = contributedRepositories
+	}
+	return nil
+}
+
+func (r *replay) page(entry *app.Entry) error {
+	variables := query.OwnerArguments{}
+	if err := entry.Variables(&variables); err != nil {
+		return err
+	}
+	res := response.User{}
+	if err := json.Unmarshal(entry.Response, &res); err != nil {
+		return err
+	}
+	login := variables.Login
+	repositories := r.userRepositories
+	if _, ok := r.organizationRepositories[login]; ok {
+		repositories = r.organizationRepositories
+	} else if _, ok := r.userRepositories[login]; !ok {
+		logger.Warning(fmt.Sprintf("Skipping %s page of %s without resources...", entry.Operation(), login))
+		return nil
+	}
+	for _, edge := range res.Data.User.Gists.Edges {
+		r.gists[login] = append(r.gists[login], edge.Node)
+	}
+	for _, edge := range res.Data.User.Repositories.Edges {
+		repositories[login] = append(repositories[login], edge.Node)
+	}
+	for _, edge := range res.Data.User.ContributedRepositories.Edges {
+		r.contributedRepositories[login] = append(r.contributedRepositories[login], edge.Node)
+	}
+	return nil
+}
+
+func (r *replay) flushUsers(ctx context.Context) error {
+	for login, repositories := range r.userRepositories {
+		user := model.User{Login: login}
+		if err := r.userModel.UpdateGists(ctx, user, r.gists[login]); err != nil {
+			return err
+		}
+		if err := r.userModel.UpdateRepositories(ctx, user, repositories); err != nil {
+			return err
+		}
+		if err := r.userModel.UpdateContributedRepositories(ctx, user, r.contributedRepositories[login]); err != nil {
+			return err
+		}
+	}
+	r.gists = map[string][]query.Gist{}
+	r.userRepositories = map[string][]model.Repository{}
+	r.contributedRepositories = map[string][]model.Repository{}
+	return nil
+}
+
+func (r *replay) flushOrganizations(ctx context.Context) error {
+	for login, repositories := range r.organizationRepositories {
+		organization := model.Organization{Login: login}
+		if err := r.organizationModel.UpdateRepositories(ctx, organization, repositories); err != nil {
+			return err
+		}
+	}
+	r.organizationRepositories = map[string][]model.Repository{}
+	return nil
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/memochou1993/gh-rankings/app/query"
 	"github.com/memochou1993/gh-rankings/app/response"
 	"github.com/memochou1993/gh-rankings/logger"
-	"strconv"
 	"time"
 )
 
@@ -23,102 +22,191 @@ type Repository struct {
 	SearchQuery     *query.Query
 }
 
-func (r *Repository) Init() {
-	r.Worker.load(timestampRepository)
-}
-
-func (r *Repository) Collect() error {
+func (r *Repository) Collect(ctx context.Context) error {
 	logger.Info("Collecting repositories...")
-	r.From = time.Date(2007, time.October, 1, 0, 0, 0, 0, time.UTC)
-	r.To = time.Now()
+	r.From = r.Worker.Since
+	r.To = r.Worker.now()
 
 	if r.Worker.Timestamp.IsZero() {
 		last := model.Repository{}
-		if r.RepositoryModel.Model.Last(&last); last.ID() != "" {
+		if err := r.RepositoryModel.Model.Last(ctx, &last); err != nil {
+			return err
+		}
+		if last.ID() != "" {
 			r.From = last.CreatedAt.AddDate(0, 0, -7).Truncate(24 * time.Hour)
 		}
 	}
 
-	return r.Travel()
+	if err := r.Worker.resume(ctx); err != nil {
+		return err
+	}
+	if r.Checkpoint.ID() != "" {
+		r.From = r.Checkpoint.From
+		logger.Info(fmt.Sprintf("Resuming repositories from %s...", r.From.Format(time.RFC3339)))
+	}
+
+	if err := r.Travel(ctx); err != nil {
+		return err
+	}
+
+	return r.Worker.finish(ctx)
 }
 
-func (r *Repository) Travel() error {
+func (r *Repository) Travel(ctx context.Context) error {
 	if r.From.After(r.To) {
 		return nil
 	}
 
-	var repositories []model.Repository
-	r.SearchQuery.SearchArguments.SetQuery(query.SearchRepositories(r.From, r.From.AddDate(0, 0, 7)))
+	if !r.Checkpoint.From.Equal(r.From) {
+		r.Worker.advance(r.From)
+	}
+
+	r.SearchQuery.SearchArguments.SetQuery(query.SearchRepositories(r.Checkpoint.From, r.Checkpoint.To))
+	if r.Checkpoint.Cursor != "" {
+		r.SearchQuery.SearchArguments.After = r.Checkpoint.Cursor
+	}
 	logger.Debug(fmt.Sprintf("Repository Query: %s", r.SearchQuery.SearchArguments.Query))
-	if err := r.Fetch(&repositories); err != nil {
+	if err := r.Search(ctx); err != nil {
 		return err
 	}
-	if res := r.RepositoryModel.Store(repositories); res != nil {
-		if res.ModifiedCount > 0 {
-			logger.Success(fmt.Sprintf("Updated %d repositories!", res.ModifiedCount))
-		}
-		if res.UpsertedCount > 0 {
-			logger.Success(fmt.Sprintf("Inserted %d repositories!", res.UpsertedCount))
-		}
+	r.From = r.Checkpoint.To
+
+	return r.Travel(ctx)
+}
+
+func (r *Repository) Search(ctx context.Context) error {
+	var repositories []model.Repository
+	res := response.Repository{}
+	if err := r.query(ctx, *r.SearchQuery, &res); err != nil {
+		return err
+	}
+	if r.Checkpoint.Cursor == "" && r.Worker.split(res.Data.Search.RepositoryCount) {
+		r.SearchQuery.SearchArguments.SetQuery(query.SearchRepositories(r.Checkpoint.From, r.Checkpoint.To))
+		logger.Debug(fmt.Sprintf("Repository Query: %s", r.SearchQuery.SearchArguments.Query))
+		return r.Search(ctx)
+	}
+	for _, edge := range res.Data.Search.Edges {
+		repositories = append(repositories, edge.Node)
+	}
+	if err := r.store(ctx, repositories); err != nil {
+		return err
+	}
+	if !res.Data.Search.PageInfo.HasNextPage {
+		r.SearchQuery.SearchArguments.After = ""
+		r.Checkpoint.Cursor = ""
+		return nil
+	}
+	r.SearchQuery.SearchArguments.After = res.Data.Search.PageInfo.EndCursor
+	r.Checkpoint.Cursor = res.Data.Search.PageInfo.EndCursor
+	if err := r.Worker.checkpoint(ctx); err != nil {
+		return err
 	}
-	r.From = r.From.AddDate(0, 0, 7)
 
-	return r.Travel()
+	return r.Search(ctx)
 }
 
-func (r *Repository) Fetch(repositories *[]model.Repository) error {
+func (r *Repository) Fetch(ctx context.Context, repositories *[]model.Repository) error {
 	res := response.Repository{}
-	if err := r.query(*r.SearchQuery, &res); err != nil {
+	if err := r.query(ctx, *r.SearchQuery, &res); err != nil {
 		return err
 	}
 	for _, edge := range res.Data.Search.Edges {
 		*repositories = append(*repositories, edge.Node)
 	}
-	res.Data.RateLimit.Throttle(collecting)
 	if !res.Data.Search.PageInfo.HasNextPage {
 		r.SearchQuery.SearchArguments.After = ""
 		return nil
 	}
-	r.SearchQuery.SearchArguments.After = strconv.Quote(res.Data.Search.PageInfo.EndCursor)
+	r.SearchQuery.SearchArguments.After = res.Data.Search.PageInfo.EndCursor
 
-	return r.Fetch(repositories)
+	return r.Fetch(ctx, repositories)
+}
+
+func (r *Repository) store(ctx context.Context, repositories []model.Repository) error {
+	r.Worker.processed(len(repositories))
+	res, err := r.RepositoryModel.Store(ctx, repositories)
+	if err != nil {
+		return err
+	}
+	if res != nil {
+		if res.ModifiedCount > 0 {
+			logger.Success(fmt.Sprintf("Updated %d repositories!", res.ModifiedCount))
+		}
+		if res.UpsertedCount > 0 {
+			logger.Success(fmt.Sprintf("Inserted %d repositories!", res.UpsertedCount))
+		}
+	}
+	return nil
 }
 
-func (r *Repository) Rank() {
+func (r *Repository) Rank(ctx context.Context) error {
 	logger.Info("Executing repository rank pipelines...")
+	if err := r.Worker.cleanup(ctx); err != nil {
+		return err
+	}
 	pipelines := pipeline.RankRepository()
-	timestamp := time.Now()
+	timestamp, err := r.Worker.stage(ctx)
+	if err != nil {
+		return err
+	}
 	for i, p := range pipelines {
-		r.RankModel.Store(r.RepositoryModel, *p, timestamp)
+		if err := r.Worker.wait(ctx); err != nil {
+			r.discard(timestamp)
+			return err
+		}
+		if err := r.RankModel.Store(ctx, r.RepositoryModel, *p, timestamp); err != nil {
+			r.discard(timestamp)
+			return err
+		}
 		if (i+1)%10 == 0 || (i+1) == len(pipelines) {
 			logger.Success(fmt.Sprintf("Executed %d of %d repository rank pipelines!", i+1, len(pipelines)))
 		}
 	}
-	r.Worker.save(timestampRepository, timestamp)
-	r.RankModel.Delete(timestamp, app.TypeRepository)
-}
-
-func (r *Repository) query(q query.Query, res *response.Repository) (err error) {
-	err = app.Fetch(context.Background(), fmt.Sprint(q), res)
-	if res.Message != "" {
-		err = errors.New(res.Message)
-		res.Message = ""
+	if err := ctx.Err(); err != nil {
+		r.discard(timestamp)
+		return err
 	}
-	for _, err = range res.Errors {
+	if err := r.Worker.publish(ctx, timestamp); err != nil {
+		r.discard(timestamp)
 		return err
 	}
-	if err != nil {
+	return r.RankModel.Prune(ctx, app.TypeRepository, r.Worker.run.Timestamps())
+}
+
+func (r *Repository) pipelines() []*pipeline.Pipeline {
+	return pipeline.RankRepository()
+}
+
+func (r *Repository) collection() model.Interface {
+	return r.RepositoryModel
+}
+
+func (r *Repository) discard(timestamp time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.RankModel.Discard(ctx, timestamp, app.TypeRepository); err != nil {
 		logger.Error(err.Error())
-		logger.Warning("Retrying...")
-		time.Sleep(10 * time.Second)
-		return r.query(q, res)
+		return
 	}
-	return
+	logger.Warning(fmt.Sprintf("Discarded repository ranks created at %s!", timestamp.Format(time.RFC3339)))
+}
+
+func (r *Repository) query(ctx context.Context, q query.Query, res *response.Repository) error {
+	return r.Worker.retry(ctx, func() error {
+		*res = response.Repository{}
+		if err := r.source().Fetch(ctx, fmt.Sprint(q), res); err != nil {
+			return err
+		}
+		if res.Message != "" {
+			return errors.New(res.Message)
+		}
+		return graphQLError(res.Errors)
+	})
 }
 
 func NewRepositoryWorker() *Repository {
 	return &Repository{
-		Worker:          &Worker{},
+		Worker:          newWorker(app.TypeRepository),
 		RepositoryModel: model.NewRepositoryModel(),
 		RankModel:       model.NewRankModel(),
 		SearchQuery:     query.Repositories(),
@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/response"
+	"github.com/memochou1993/gh-rankings/logger"
+	"github.com/spf13/viper"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	errorTypeNotFound    = "NOT_FOUND"
+	errorTypeRateLimited = "RATE_LIMITED"
+)
+
+const (
+	Immediately time.Duration = -1
+)
+
+var (
+	ErrSkipped = errors.New("item skipped")
+)
+
+// skipError marks an error the policy will not retry, so that the caller skips the item instead of aborting the run.
+type skipError struct {
+	err error
+}
+
+func (e skipError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSkipped.Error(), e.err.Error())
+}
+
+func (e skipError) Is(target error) bool {
+	return target == ErrSkipped
+}
+
+func (e skipError) Unwrap() error {
+	return e.err
+}
+
+func skip(err error) error {
+	if errors.Is(err, ErrSkipped) {
+		return err
+	}
+	return skipError{err: err}
+}
+
+type RetryPolicy struct {
+	Attempts    int
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		delay, retryable := Classify(err)
+		if !retryable {
+			return skip(err)
+		}
+		if attempt >= p.Attempts {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		switch {
+		case delay == Immediately:
+			delay = 0
+		case delay <= 0:
+			delay = p.Backoff(attempt)
+		}
+		logger.Error(err.Error())
+		logger.Warning(fmt.Sprintf("Retrying in %s (attempt %d of %d)...", delay.Round(time.Second), attempt+1, p.Attempts))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.MaxInterval
+	if attempt < 32 {
+		if n := p.Interval << uint(attempt-1); n > 0 && n < d {
+			d = n
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func Classify(err error) (delay time.Duration, retryable bool) {
+	if errors.Is(err, ErrSkipped) {
+		return 0, false
+	}
+	var resErr *app.ResponseError
+	if errors.As(err, &resErr) {
+		switch {
+		case resErr.RateLimited():
+			if resetAt, exhausted := app.Tokens().Exhausted(); exhausted {
+				return time.Until(resetAt) + time.Second, true
+			}
+			return Immediately, true
+		case resErr.StatusCode == http.StatusTooManyRequests, resErr.StatusCode >= http.StatusInternalServerError:
+			return 0, true
+		}
+		return 0, false
+	}
+	var gqlErr response.Error
+	if errors.As(err, &gqlErr) {
+		return 0, gqlErr.Type == errorTypeRateLimited || gqlErr.Type == ""
+	}
+	return 0, true
+}
+
+func NewRetryPolicy() RetryPolicy {
+	p := RetryPolicy{
+		Attempts:    10,
+		Interval:    2 * time.Second,
+		MaxInterval: 5 * time.Minute,
+	}
+	if n := viper.GetInt("RETRY_ATTEMPTS"); n > 0 {
+		p.Attempts = n
+	}
+	if d := viper.GetDuration("RETRY_INTERVAL"); d > 0 {
+		p.Interval = d
+	}
+	if d := viper.GetDuration("RETRY_MAX_INTERVAL"); d > 0 {
+		p.MaxInterval = d
+	}
+	return p
+}
+
+func graphQLError(errs []response.Error) error {
+	for _, err := range errs {
+		if err.Type == errorTypeNotFound {
+			return fmt.Errorf("%w: %s", ErrSkipped, err.Message)
+		}
+	}
+	for _, err := range errs {
+		return err
+	}
+	return nil
+}
+
+func (w *Worker) retry(ctx context.Context, fn func() error) error {
+	return NewRetryPolicy().Do(ctx, fn)
+}
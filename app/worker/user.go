@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/memochou1993/gh-rankings/app"
@@ -11,230 +12,541 @@ import (
 	"github.com/memochou1993/gh-rankings/app/resource"
 	"github.com/memochou1993/gh-rankings/app/response"
 	"github.com/memochou1993/gh-rankings/logger"
-	"strconv"
+	"github.com/spf13/viper"
 	"time"
 )
 
 type User struct {
 	*Worker
-	From            time.Time
-	To              time.Time
-	UserModel       *model.UserModel
-	RankModel       *model.RankModel
-	SearchQuery     *query.Query
-	GistQuery       *query.Query
-	RepositoryQuery *query.Query
+	From                       time.Time
+	To                         time.Time
+	UserModel                  *model.UserModel
+	RankModel                  *model.RankModel
+	SearchQuery                *query.Query
+	GistQuery                  *query.Query
+	RepositoryQuery            *query.Query
+	ContributedRepositoryQuery *query.Query
 }
 
-func (u *User) Init() {
-	u.Worker.load(timestampUser)
-}
-
-func (u *User) Collect() error {
+func (u *User) Collect(ctx context.Context) error {
 	logger.Info("Collecting users...")
-	u.From = time.Date(2007, time.October, 1, 0, 0, 0, 0, time.UTC)
-	u.To = time.Now()
+	u.From = u.Worker.Since
+	u.To = u.Worker.now()
 
 	if u.Worker.Timestamp.IsZero() {
 		last := model.User{}
-		if u.UserModel.Model.Last(&last); last.ID() != "" {
+		if err := u.UserModel.Model.Last(ctx, &last); err != nil {
+			return err
+		}
+		if last.ID() != "" {
 			u.From = last.CreatedAt.AddDate(0, 0, -7).Truncate(24 * time.Hour)
 		}
 	}
 
-	if err := u.Prepare(); err != nil {
+	if err := u.Prepare(ctx); err != nil {
 		return err
 	}
 
-	return u.Travel()
+	if err := u.Worker.resume(ctx); err != nil {
+		return err
+	}
+	if u.Checkpoint.ID() != "" {
+		u.From = u.Checkpoint.From
+		logger.Info(fmt.Sprintf("Resuming users from %s...", u.From.Format(time.RFC3339)))
+	}
+
+	if err := u.Travel(ctx); err != nil {
+		return err
+	}
+
+	return u.Worker.finish(ctx)
 }
 
-func (u *User) Prepare() error {
+func (u *User) Prepare(ctx context.Context) error {
 	for _, user := range resource.SpecifiedUsers {
-		var users []model.User
-		u.SearchQuery.SearchArguments.SetQuery(query.SearchSpecifiedUser(user.Login))
-		logger.Debug(fmt.Sprintf("User Query: %s", u.SearchQuery.SearchArguments.Query))
-		if err := u.Fetch(&users); err != nil {
+		if err := u.Pull(ctx, user.Login); errors.Is(err, ErrSkipped) {
+			logger.Warning(fmt.Sprintf("Skipped user %s: %s", user.Login, err.Error()))
+		} else if err != nil {
 			return err
 		}
+	}
 
-		if res := u.UserModel.Store(users); res != nil {
-			if res.ModifiedCount > 0 {
-				logger.Success(fmt.Sprintf("Updated %d users!", res.ModifiedCount))
-			}
-			if res.UpsertedCount > 0 {
-				logger.Success(fmt.Sprintf("Inserted %d users!", res.UpsertedCount))
-			}
+	return nil
+}
+
+func (u *User) Pull(ctx context.Context, login string) error {
+	var users []model.User
+	u.SearchQuery.SearchArguments.SetQuery(query.SearchSpecifiedUser(login))
+	logger.Debug(fmt.Sprintf("User Query: %s", u.SearchQuery.SearchArguments.Query))
+	if err := u.Fetch(ctx, &users); err != nil {
+		return err
+	}
+
+	if err := u.store(ctx, users); err != nil {
+		return err
+	}
+	if err := u.Update(ctx, users); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u *User) Refresh(ctx context.Context) error {
+	logger.Info("Refreshing users...")
+	before := time.Now()
+	for limit := refreshLimit(); limit > 0; limit -= refreshBatch {
+		size := refreshBatch
+		if limit < size {
+			size = limit
 		}
-		for _, user := range users {
-			if err := u.Update(user); err != nil {
-				return err
-			}
+		stale, err := u.UserModel.Stale(ctx, before, int64(size))
+		if err != nil {
+			return err
+		}
+		if len(stale) == 0 {
+			break
+		}
+		var logins []string
+		for _, user := range stale {
+			logins = append(logins, user.ID())
 		}
+		var users []model.User
+		if err := u.FetchByNodeID(ctx, stale, &users); err != nil {
+			return err
+		}
+		if err := u.store(ctx, users); err != nil {
+			return err
+		}
+		if err := u.Update(ctx, users); err != nil {
+			return err
+		}
+		if err := u.UserModel.Touch(ctx, logins); err != nil {
+			return err
+		}
+		logger.Success(fmt.Sprintf("Refreshed %d of %d users!", len(users), len(logins)))
 	}
 
 	return nil
 }
 
-func (u *User) Travel() error {
+func (u *User) Travel(ctx context.Context) error {
 	if u.From.After(u.To) {
 		return nil
 	}
 
-	var users []model.User
-	u.SearchQuery.SearchArguments.SetQuery(query.SearchUsers(u.From, u.From.AddDate(0, 0, 7)))
-	logger.Debug(fmt.Sprintf("User Query: %s", u.SearchQuery.SearchArguments.Query))
-	if err := u.Fetch(&users); err != nil {
-		return err
+	if !u.Checkpoint.From.Equal(u.From) {
+		u.Worker.advance(u.From)
 	}
 
-	if res := u.UserModel.Store(users); res != nil {
-		if res.ModifiedCount > 0 {
-			logger.Success(fmt.Sprintf("Updated %d users!", res.ModifiedCount))
+	if u.Checkpoint.Phase == model.PhaseSearch {
+		u.SearchQuery.SearchArguments.SetQuery(query.SearchUsers(u.Checkpoint.From, u.Checkpoint.To))
+		if u.Checkpoint.Cursor != "" {
+			u.SearchQuery.SearchArguments.After = u.Checkpoint.Cursor
 		}
-		if res.UpsertedCount > 0 {
-			logger.Success(fmt.Sprintf("Inserted %d users!", res.UpsertedCount))
+		logger.Debug(fmt.Sprintf("User Query: %s", u.SearchQuery.SearchArguments.Query))
+		if err := u.Search(ctx); err != nil {
+			return err
 		}
 	}
-	for _, user := range users {
-		if err := u.Update(user); err != nil {
+
+	pending := u.Checkpoint.Pending()
+	for start := 0; start < len(pending); start += resourceBatch {
+		end := start + resourceBatch
+		if end > len(pending) {
+			end = len(pending)
+		}
+		u.Checkpoint.Phase = model.PhaseResources
+		u.Checkpoint.Owner = pending[start]
+		if err := u.Worker.checkpoint(ctx); err != nil {
+			return err
+		}
+		var users []model.User
+		for _, login := range pending[start:end] {
+			users = append(users, model.User{Login: login})
+		}
+		if err := u.UpdateResources(ctx, users); err != nil {
 			return err
 		}
 	}
-	u.From = u.From.AddDate(0, 0, 7)
+	u.From = u.Checkpoint.To
 
-	return u.Travel()
+	return u.Travel(ctx)
 }
 
-func (u *User) Fetch(users *[]model.User) error {
+func (u *User) Search(ctx context.Context) error {
+	var users []model.User
 	res := response.User{}
-	if err := u.query(*u.SearchQuery, &res); err != nil {
+	if err := u.query(ctx, *u.SearchQuery, &res); err != nil {
+		return err
+	}
+	if u.Checkpoint.Cursor == "" && u.Worker.split(res.Data.Search.UserCount) {
+		u.SearchQuery.SearchArguments.SetQuery(query.SearchUsers(u.Checkpoint.From, u.Checkpoint.To))
+		logger.Debug(fmt.Sprintf("User Query: %s", u.SearchQuery.SearchArguments.Query))
+		return u.Search(ctx)
+	}
+	for _, edge := range res.Data.Search.Edges {
+		users = append(users, edge.Node)
+		u.Checkpoint.Owners = append(u.Checkpoint.Owners, edge.Node.ID())
+	}
+	if err := u.store(ctx, users); err != nil {
+		return err
+	}
+	if !res.Data.Search.PageInfo.HasNextPage {
+		u.SearchQuery.SearchArguments.After = ""
+		u.Checkpoint.Phase = model.PhaseResources
+		u.Checkpoint.Cursor = ""
+		return u.Worker.checkpoint(ctx)
+	}
+	u.SearchQuery.SearchArguments.After = res.Data.Search.PageInfo.EndCursor
+	u.Checkpoint.Cursor = res.Data.Search.PageInfo.EndCursor
+	if err := u.Worker.checkpoint(ctx); err != nil {
+		return err
+	}
+
+	return u.Search(ctx)
+}
+
+func (u *User) Fetch(ctx context.Context, users *[]model.User) error {
+	res := response.User{}
+	if err := u.query(ctx, *u.SearchQuery, &res); err != nil {
 		return err
 	}
 	for _, edge := range res.Data.Search.Edges {
 		*users = append(*users, edge.Node)
 	}
-	res.Data.RateLimit.Throttle(collecting)
 	if !res.Data.Search.PageInfo.HasNextPage {
 		u.SearchQuery.SearchArguments.After = ""
 		return nil
 	}
-	u.SearchQuery.SearchArguments.After = strconv.Quote(res.Data.Search.PageInfo.EndCursor)
+	u.SearchQuery.SearchArguments.After = res.Data.Search.PageInfo.EndCursor
 
-	return u.Fetch(users)
+	return u.Fetch(ctx, users)
 }
 
-func (u *User) Update(user model.User) error {
-	u.GistQuery.Type = app.TypeUser
-	u.GistQuery.OwnerArguments.Login = strconv.Quote(user.ID())
-	if err := u.UpdateGists(user); err != nil {
+func (u *User) FetchByNodeID(ctx context.Context, owners []model.User, users *[]model.User) error {
+	var logins []string
+	var nodeIDs []string
+	for _, owner := range owners {
+		logins = append(logins, owner.ID())
+		nodeIDs = append(nodeIDs, owner.NodeID)
+	}
+	q := query.OwnersByLogin(logins)
+	q.NodeIDs = nodeIDs
+	data, errs, err := u.Worker.batch(ctx, q)
+	if errors.Is(err, ErrSkipped) {
+		logger.Warning(fmt.Sprintf("Skipped %d users: %s", len(logins), err.Error()))
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	var gone []string
+	for i, login := range logins {
+		if err, ok := errs[query.Alias(i)]; ok && err.Type != errorTypeNotFound {
+			logger.Warning(fmt.Sprintf("Skipped user %s: %s", login, err.Error()))
+			continue
+		}
+		raw, ok := data[query.Alias(i)]
+		if !ok || string(raw) == "null" {
+			gone = append(gone, login)
+			continue
+		}
+		user := model.User{}
+		if err := json.Unmarshal(raw, &user); err != nil {
+			return err
+		}
+		if user.ID() != "" {
+			*users = append(*users, user)
+		}
+	}
 
-	u.RepositoryQuery.Type = app.TypeUser
-	u.RepositoryQuery.OwnerArguments.Login = strconv.Quote(user.ID())
-	if err := u.UpdateRepositories(user); err != nil {
-		return err
+	return u.gone(ctx, gone)
+}
+
+func (u *User) Update(ctx context.Context, users []model.User) error {
+	for start := 0; start < len(users); start += resourceBatch {
+		end := start + resourceBatch
+		if end > len(users) {
+			end = len(users)
+		}
+		if err := u.Worker.wait(ctx); err != nil {
+			return err
+		}
+		if err := u.UpdateResources(ctx, users[start:end]); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (u *User) UpdateGists(user model.User) error {
-	var gists []query.Gist
-	if err := u.FetchGists(&gists); err != nil {
+func (u *User) UpdateResources(ctx context.Context, users []model.User) error {
+	var logins []string
+	for _, user := range users {
+		logins = append(logins, user.ID())
+	}
+	nodeIDs, err := u.UserModel.NodeIDs(ctx, logins)
+	if err != nil {
 		return err
 	}
-	u.UserModel.UpdateGists(user, gists)
-	logger.Success(fmt.Sprintf("Updated %d %s gists!", len(gists), app.TypeUser))
-	return nil
-}
+	q := query.OwnerResources(app.TypeUser, logins)
+	for _, login := range logins {
+		q.NodeIDs = append(q.NodeIDs, nodeIDs[login])
+	}
+	q.ContributionsArguments = query.Contributions(contributionWindow())
+	data, errs, err := u.Worker.batch(ctx, q)
+	if errors.Is(err, ErrSkipped) {
+		logger.Warning(fmt.Sprintf("Skipped resources of %d users: %s", len(users), err.Error()))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var gone []string
+	for i, user := range users {
+		if err, ok := errs[query.Alias(i)]; ok && err.Type != errorTypeNotFound {
+			logger.Warning(fmt.Sprintf("Skipped resources of user %s: %s", user.ID(), err.Error()))
+			continue
+		}
+		raw, ok := data[query.Alias(i)]
+		if !ok || string(raw) == "null" {
+			gone = append(gone, user.ID())
+			continue
+		}
+		res := response.Resources{}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		login := user.ID()
+		if res.Login != "" {
+			login = res.Login
+		}
+		if res.ContributionsCollection != nil {
+			if err := u.UserModel.UpdateContributions(ctx, user, res.ContributionsCollection.Contributions()); err != nil {
+				return err
+			}
+		}
 
-func (u *User) UpdateRepositories(user model.User) error {
-	var repositories []model.Repository
-	if err := u.FetchRepositories(&repositories); err != nil {
+		var gists []query.Gist
+		for _, edge := range res.Gists.Edges {
+			gists = append(gists, edge.Node)
+		}
+		if res.Gists.PageInfo.HasNextPage {
+			u.GistQuery.OwnerArguments.Login = login
+			u.GistQuery.GistsArguments.After = res.Gists.PageInfo.EndCursor
+			if err := u.FetchGists(ctx, &gists); errors.Is(err, ErrSkipped) {
+				logger.Warning(fmt.Sprintf("Skipped remaining gists of user %s: %s", user.ID(), err.Error()))
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := u.UserModel.UpdateGists(ctx, user, gists); err != nil {
+			return err
+		}
+
+		var repositories []model.Repository
+		for _, edge := range res.Repositories.Edges {
+			repositories = append(repositories, edge.Node)
+		}
+		if res.Repositories.PageInfo.HasNextPage {
+			u.RepositoryQuery.OwnerArguments.Login = login
+			u.RepositoryQuery.RepositoriesArguments.After = res.Repositories.PageInfo.EndCursor
+			if err := u.FetchRepositories(ctx, &repositories); errors.Is(err, ErrSkipped) {
+				logger.Warning(fmt.Sprintf("Skipped remaining repositories of user %s: %s", user.ID(), err.Error()))
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := u.UserModel.UpdateRepositories(ctx, user, repositories); err != nil {
+			return err
+		}
+
+		var contributedRepositories []model.Repository
+		for _, edge := range res.ContributedRepositories.Edges {
+			contributedRepositories = append(contributedRepositories, edge.Node)
+		}
+		if res.ContributedRepositories.PageInfo.HasNextPage {
+			u.ContributedRepositoryQuery.OwnerArguments.Login = login
+			u.ContributedRepositoryQuery.RepositoriesArguments.After = res.ContributedRepositories.PageInfo.EndCursor
+			if err := u.FetchContributedRepositories(ctx, &contributedRepositories); errors.Is(err, ErrSkipped) {
+				logger.Warning(fmt.Sprintf("Skipped remaining contributed repositories of user %s: %s", user.ID(), err.Error()))
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := u.UserModel.UpdateContributedRepositories(ctx, user, contributedRepositories); err != nil {
+			return err
+		}
+	}
+	if err := u.gone(ctx, gone); err != nil {
 		return err
 	}
-	u.UserModel.UpdateRepositories(user, repositories)
-	logger.Success(fmt.Sprintf("Updated %d %s repositories!", len(repositories), app.TypeUser))
+	logger.Success(fmt.Sprintf("Updated contributions, gists, repositories and contributed repositories of %d users!", len(users)))
 	return nil
 }
 
-func (u *User) FetchGists(gists *[]query.Gist) error {
+func (u *User) FetchGists(ctx context.Context, gists *[]query.Gist) error {
 	res := response.User{}
-	if err := u.query(*u.GistQuery, &res); err != nil {
+	if err := u.query(ctx, *u.GistQuery, &res); err != nil {
 		return err
 	}
 	for _, edge := range res.Data.User.Gists.Edges {
 		*gists = append(*gists, edge.Node)
 	}
-	res.Data.RateLimit.Throttle(collecting)
 	if !res.Data.User.Gists.PageInfo.HasNextPage {
 		u.GistQuery.GistsArguments.After = ""
 		return nil
 	}
-	u.GistQuery.GistsArguments.After = strconv.Quote(res.Data.User.Gists.PageInfo.EndCursor)
+	u.GistQuery.GistsArguments.After = res.Data.User.Gists.PageInfo.EndCursor
 
-	return u.FetchGists(gists)
+	return u.FetchGists(ctx, gists)
 }
 
-func (u *User) FetchRepositories(repositories *[]model.Repository) error {
+func (u *User) FetchRepositories(ctx context.Context, repositories *[]model.Repository) error {
 	res := response.User{}
-	if err := u.query(*u.RepositoryQuery, &res); err != nil {
+	if err := u.query(ctx, *u.RepositoryQuery, &res); err != nil {
 		return err
 	}
 	for _, edge := range res.Data.User.Repositories.Edges {
 		*repositories = append(*repositories, edge.Node)
 	}
-	res.Data.RateLimit.Throttle(collecting)
 	if !res.Data.User.Repositories.PageInfo.HasNextPage {
 		u.RepositoryQuery.RepositoriesArguments.After = ""
 		return nil
 	}
-	u.RepositoryQuery.RepositoriesArguments.After = strconv.Quote(res.Data.User.Repositories.PageInfo.EndCursor)
+	u.RepositoryQuery.RepositoriesArguments.After = res.Data.User.Repositories.PageInfo.EndCursor
 
-	return u.FetchRepositories(repositories)
+	return u.FetchRepositories(ctx, repositories)
 }
 
-func (u *User) Rank() {
+func (u *User) FetchContributedRepositories(ctx context.Context, repositories *[]model.Repository) error {
+	res := response.User{}
+	if err := u.query(ctx, *u.ContributedRepositoryQuery, &res); err != nil {
+		return err
+	}
+	for _, edge := range res.Data.User.ContributedRepositories.Edges {
+		*repositories = append(*repositories, edge.Node)
+	}
+	if !res.Data.User.ContributedRepositories.PageInfo.HasNextPage {
+		u.ContributedRepositoryQuery.RepositoriesArguments.After = ""
+		return nil
+	}
+	u.ContributedRepositoryQuery.RepositoriesArguments.After = res.Data.User.ContributedRepositories.PageInfo.EndCursor
+
+	return u.FetchContributedRepositories(ctx, repositories)
+}
+
+func (u *User) gone(ctx context.Context, logins []string) error {
+	if len(logins) == 0 {
+		return nil
+	}
+	if err := u.UserModel.MarkGone(ctx, logins); err != nil {
+		return err
+	}
+	logger.Warning(fmt.Sprintf("Marked %d users as gone!", len(logins)))
+	return nil
+}
+
+func (u *User) store(ctx context.Context, users []model.User) error {
+	u.Worker.processed(len(users))
+	res, err := u.UserModel.Store(ctx, users)
+	if err != nil {
+		return err
+	}
+	if res != nil {
+		if res.ModifiedCount > 0 {
+			logger.Success(fmt.Sprintf("Updated %d users!", res.ModifiedCount))
+		}
+		if res.UpsertedCount > 0 {
+			logger.Success(fmt.Sprintf("Inserted %d users!", res.UpsertedCount))
+		}
+	}
+	return nil
+}
+
+func (u *User) Rank(ctx context.Context) error {
 	logger.Info("Executing user rank pipelines...")
+	if err := u.Worker.cleanup(ctx); err != nil {
+		return err
+	}
 	pipelines := pipeline.RankUser()
-	timestamp := time.Now()
+	timestamp, err := u.Worker.stage(ctx)
+	if err != nil {
+		return err
+	}
 	for i, p := range pipelines {
-		u.RankModel.Store(u.UserModel, *p, timestamp)
+		if err := u.Worker.wait(ctx); err != nil {
+			u.discard(timestamp)
+			return err
+		}
+		if err := u.RankModel.Store(ctx, u.UserModel, *p, timestamp); err != nil {
+			u.discard(timestamp)
+			return err
+		}
 		if (i+1)%10 == 0 || (i+1) == len(pipelines) {
 			logger.Success(fmt.Sprintf("Executed %d of %d user rank pipelines!", i+1, len(pipelines)))
 		}
 	}
-	u.Worker.save(timestampUser, timestamp)
-	u.RankModel.Delete(timestamp, app.TypeUser)
-}
-
-func (u *User) query(q query.Query, res *response.User) (err error) {
-	err = app.Fetch(context.Background(), fmt.Sprint(q), res)
-	if res.Message != "" {
-		err = errors.New(res.Message)
-		res.Message = ""
+	if err := ctx.Err(); err != nil {
+		u.discard(timestamp)
+		return err
 	}
-	for _, err := range res.Errors {
+	if err := u.Worker.publish(ctx, timestamp); err != nil {
+		u.discard(timestamp)
 		return err
 	}
-	if err != nil {
+	return u.RankModel.Prune(ctx, app.TypeUser, u.Worker.run.Timestamps())
+}
+
+func (u *User) pipelines() []*pipeline.Pipeline {
+	return pipeline.RankUser()
+}
+
+func (u *User) collection() model.Interface {
+	return u.UserModel
+}
+
+func (u *User) discard(timestamp time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := u.RankModel.Discard(ctx, timestamp, app.TypeUser); err != nil {
 		logger.Error(err.Error())
-		logger.Warning("Retrying...")
-		time.Sleep(10 * time.Second)
-		return u.query(q, res)
+		return
+	}
+	logger.Warning(fmt.Sprintf("Discarded user ranks created at %s!", timestamp.Format(time.RFC3339)))
+}
+
+func (u *User) query(ctx context.Context, q query.Query, res *response.User) error {
+	return u.Worker.retry(ctx, func() error {
+		*res = response.User{}
+		if err := u.source().Fetch(ctx, fmt.Sprint(q), res); err != nil {
+			return err
+		}
+		if res.Message != "" {
+			return errors.New(res.Message)
+		}
+		return graphQLError(res.Errors)
+	})
+}
+
+func contributionWindow() (from, to time.Time) {
+	if year := viper.GetInt("CONTRIBUTION_YEAR"); year > 0 {
+		from = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(1, 0, 0).Add(-time.Second)
 	}
-	return
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	return to.AddDate(-1, 0, 0).Add(time.Second), to
 }
 
 func NewUserWorker() *User {
 	return &User{
-		Worker:          &Worker{},
-		UserModel:       model.NewUserModel(),
-		RankModel:       model.NewRankModel(),
-		SearchQuery:     query.Owners(),
-		GistQuery:       query.OwnerGists(),
-		RepositoryQuery: query.OwnerRepositories(),
+		Worker:                     newWorker(app.TypeUser),
+		UserModel:                  model.NewUserModel(),
+		RankModel:                  model.NewRankModel(),
+		SearchQuery:                query.Owners(),
+		GistQuery:                  query.OwnerGists(),
+		RepositoryQuery:            query.OwnerRepositories(),
+		ContributedRepositoryQuery: query.OwnerContributedRepositories(),
 	}
 }
@@ -1,75 +1,838 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
 	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/pipeline"
+	"github.com/memochou1993/gh-rankings/app/query"
+	"github.com/memochou1993/gh-rankings/app/response"
+	"github.com/memochou1993/gh-rankings/app/schedule"
 	"github.com/memochou1993/gh-rankings/logger"
 	"github.com/spf13/viper"
-	"log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	timestampUser         = "TIMESTAMP_USER"
-	timestampOrganization = "TIMESTAMP_ORGANIZATION"
-	timestampRepository   = "TIMESTAMP_REPOSITORY"
+	searchLimit   = 1000
+	minWindow     = time.Hour
+	maxWindow     = 28 * 24 * time.Hour
+	defaultWindow = 7 * 24 * time.Hour
+	retryInterval = time.Minute
+)
+
+const (
+	defaultSchedule = "@weekly"
+	refreshBatch    = 50
+	resourceBatch   = 10
+	abandonAfter    = 24 * time.Hour
+)
+
+const (
+	CommandCollect = "collect"
+	CommandRank    = "rank"
+	CommandRefresh = "refresh"
+)
+
+const (
+	StateIdle       = "idle"
+	StateCollecting = "collecting"
+	StateRanking    = "ranking"
+	StateRefreshing = "refreshing"
 )
 
 var (
-	UserWorker         = NewUserWorker()
-	OrganizationWorker = NewOrganizationWorker()
-	RepositoryWorker   = NewRepositoryWorker()
+	ErrNotFound     = errors.New("worker not found")
+	ErrBusy         = errors.New("worker is busy")
+	ErrUnpublished  = errors.New("no published snapshot")
+	ErrUnsupported  = errors.New("command not supported")
+	ErrUnknownField = errors.New("field not ranked")
 )
 
 var (
-	collecting int64
+	checkpointModel = model.NewCheckpointModel()
+	workerRunModel  = model.NewWorkerRunModel()
+	rankModel       = model.NewRankModel()
+	wg              sync.WaitGroup
+)
+
+var (
+	UserWorker         = NewUserWorker()
+	OrganizationWorker = NewOrganizationWorker()
+	RepositoryWorker   = NewRepositoryWorker()
 )
 
 type Interface interface {
-	Init()
-	Collect() error
-	Rank()
+	Collect(ctx context.Context) error
+	Rank(ctx context.Context) error
+	pipelines() []*pipeline.Pipeline
+	collection() model.Interface
+	base() *Worker
+}
+
+type refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+type Status struct {
+	Type        string    `json:"type"`
+	State       string    `json:"state"`
+	Paused      bool      `json:"paused"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Timestamp   time.Time `json:"timestamp"`
+	NextCollect time.Time `json:"nextCollect"`
+	NextRank    time.Time `json:"nextRank"`
+	NextRefresh time.Time `json:"nextRefresh"`
+	LastError   string    `json:"lastError"`
+	Processed   int       `json:"processed"`
+	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
 type Worker struct {
-	Timestamp time.Time
+	Type       string
+	Timestamp  time.Time
+	Since      time.Time
+	Clock      func() time.Time
+	Source     app.Source
+	Checkpoint model.Checkpoint
+	run        model.WorkerRun
+	status     Status
+	paused     chan struct{}
+	commands   chan string
+	mu         sync.Mutex
 }
 
-func (w *Worker) load(timestamp string) {
-	if timestamp := viper.GetInt64(timestamp); timestamp > 0 {
-		w.Timestamp = time.Unix(0, timestamp)
+func (w *Worker) base() *Worker {
+	return w
+}
+
+func (w *Worker) source() app.Source {
+	if w.Source == nil {
+		w.Source = app.NewSource()
 	}
+	return w.Source
 }
 
-func (w *Worker) save(key string, t time.Time) {
-	w.Timestamp = t
-	viper.Set(key, t.UnixNano())
-	if err := viper.WriteConfig(); err != nil {
-		log.Fatal(err.Error())
+func (w *Worker) now() time.Time {
+	if w.Clock == nil {
+		return time.Now()
 	}
+	return w.Clock()
 }
 
-func Start() {
-	model.NewRankModel().CreateIndexes()
+func (w *Worker) load(ctx context.Context) error {
+	run, err := workerRunModel.Snapshot(ctx, w.Type)
+	if err != nil {
+		return err
+	}
+	if run.Snapshot.IsZero() {
+		if run, err = w.migrate(ctx); err != nil {
+			return err
+		}
+	}
+	w.Timestamp = run.Snapshot
+	w.update(func(s *Status) {
+		s.Timestamp = w.Timestamp
+	})
+	return nil
+}
 
-	go run(UserWorker, 7*24*time.Hour)
-	go run(OrganizationWorker, 7*24*time.Hour)
-	go run(RepositoryWorker, 7*24*time.Hour)
+func (w *Worker) migrate(ctx context.Context) (run model.WorkerRun, err error) {
+	timestamp := viper.GetInt64(fmt.Sprintf("TIMESTAMP_%s", strings.ToUpper(w.Type)))
+	if timestamp <= 0 {
+		return
+	}
+	run = model.WorkerRun{
+		ID:         primitive.NewObjectID(),
+		Worker:     w.Type,
+		Command:    CommandRank,
+		Status:     model.RunStatusSucceeded,
+		StartedAt:  time.Unix(0, timestamp),
+		FinishedAt: time.Unix(0, timestamp),
+		Snapshot:   time.Unix(0, timestamp),
+	}
+	err = workerRunModel.Store(ctx, run)
+	return
+}
+
+func (w *Worker) begin(ctx context.Context, command string) error {
+	w.run = model.WorkerRun{
+		ID:        primitive.NewObjectID(),
+		Worker:    w.Type,
+		Command:   command,
+		Status:    model.RunStatusRunning,
+		StartedAt: time.Now(),
+	}
+	return workerRunModel.Store(ctx, w.run)
+}
+
+func (w *Worker) end(err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w.run.Status = model.RunStatusSucceeded
+	switch {
+	case errors.Is(err, context.Canceled):
+		w.run.Status = model.RunStatusCancelled
+	case err != nil:
+		w.run.Status = model.RunStatusFailed
+		w.run.Error = err.Error()
+	}
+	w.run.FinishedAt = time.Now()
+	w.run.Processed = w.Status().Processed
+	if err := workerRunModel.Store(ctx, w.run); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func (w *Worker) resume(ctx context.Context) (err error) {
+	w.Checkpoint, err = checkpointModel.FindByID(ctx, w.Type)
+	return
+}
+
+func (w *Worker) advance(from time.Time) {
+	window := defaultWindow
+	if last := w.Checkpoint; !last.From.IsZero() {
+		window = last.To.Sub(last.From)
+		if last.Count < searchLimit/2 {
+			window *= 2
+		}
+		if window > maxWindow {
+			window = maxWindow
+		}
+	}
+	w.Checkpoint = model.Checkpoint{
+		Worker: w.Type,
+		Phase:  model.PhaseSearch,
+		From:   from,
+		To:     from.Add(window),
+	}
+}
+
+func (w *Worker) split(count int) bool {
+	w.Checkpoint.Count = count
+	if count <= searchLimit {
+		return false
+	}
+	window := w.Checkpoint.To.Sub(w.Checkpoint.From)
+	if window <= minWindow {
+		logger.Warning(fmt.Sprintf("Skipped %d %s results between %s and %s!", count-searchLimit, w.Type, w.Checkpoint.From.Format(time.RFC3339), w.Checkpoint.To.Format(time.RFC3339)))
+		return false
+	}
+	window = (window / 2).Truncate(minWindow)
+	if window < minWindow {
+		window = minWindow
+	}
+	w.Checkpoint.To = w.Checkpoint.From.Add(window)
+	return true
 }
 
-func run(worker Interface, d time.Duration) {
-	worker.Init()
+func (w *Worker) checkpoint(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.Checkpoint.UpdatedAt = time.Now()
+	if err := checkpointModel.Store(ctx, w.Checkpoint); err != nil {
+		return err
+	}
+	w.update(func(s *Status) {
+		s.From = w.Checkpoint.From
+		s.To = w.Checkpoint.To
+	})
+	return w.wait(ctx)
+}
 
-	t := time.NewTicker(d)
-	for ; true; <-t.C {
-		var err error
-		collecting += 1
-		if err = worker.Collect(); err != nil {
+func (w *Worker) finish(ctx context.Context) error {
+	if err := checkpointModel.Delete(ctx, w.Checkpoint.ID()); err != nil {
+		return err
+	}
+	w.Checkpoint = model.Checkpoint{}
+	return nil
+}
+
+// cleanup discards the staging ranks of failed or abandoned runs, leaving those of runs still in progress in other processes.
+func (w *Worker) cleanup(ctx context.Context) error {
+	runs, err := workerRunModel.Abandoned(ctx, w.Type, time.Now().Add(-abandonAfter))
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if err := rankModel.Discard(ctx, run.Staging, w.Type); err != nil {
+			return err
+		}
+		if err := workerRunModel.Unstage(ctx, run); err != nil {
+			return err
+		}
+		logger.Warning(fmt.Sprintf("Discarded %s ranks of %s run started at %s!", w.Type, run.Status, run.StartedAt.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// stage records the creation time of the ranks the current run is about to write.
+func (w *Worker) stage(ctx context.Context) (time.Time, error) {
+	w.run.Staging = time.Now()
+	if err := workerRunModel.Store(ctx, w.run); err != nil {
+		return time.Time{}, err
+	}
+	return w.run.Staging, nil
+}
+
+func (w *Worker) publish(ctx context.Context, createdAt time.Time) error {
+	w.run.Snapshot = createdAt
+	if err := workerRunModel.Store(ctx, w.run); err != nil {
+		return err
+	}
+	w.Timestamp = createdAt
+	w.update(func(s *Status) {
+		s.Timestamp = createdAt
+	})
+	logger.Success(fmt.Sprintf("Published %s ranks created at %s!", w.Type, createdAt.Format(time.RFC3339)))
+	return nil
+}
+
+func (w *Worker) processed(n int) {
+	w.update(func(s *Status) {
+		s.Processed += n
+	})
+}
+
+func (w *Worker) update(f func(s *Status)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f(&w.status)
+	w.status.UpdatedAt = time.Now()
+}
+
+func (w *Worker) wait(ctx context.Context) error {
+	w.mu.Lock()
+	paused := w.paused
+	w.mu.Unlock()
+	if paused != nil {
+		logger.Warning(fmt.Sprintf("Paused %s worker!", w.Type))
+		select {
+		case <-paused:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+func (w *Worker) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.status
+	s.Type = w.Type
+	s.Paused = w.paused != nil
+	if s.State == "" {
+		s.State = StateIdle
+	}
+	return s
+}
+
+func (w *Worker) Trigger(command string) error {
+	if worker, err := lookup(w.Type); err != nil || !supports(worker, command) {
+		return ErrUnsupported
+	}
+	if w.Status().State != StateIdle {
+		return ErrBusy
+	}
+	select {
+	case w.commands <- command:
+		return nil
+	default:
+		return ErrBusy
+	}
+}
+
+func (w *Worker) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused == nil {
+		w.paused = make(chan struct{})
+	}
+}
+
+func (w *Worker) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused != nil {
+		close(w.paused)
+		w.paused = nil
+	}
+}
+
+func Snapshots(ctx context.Context) ([]model.WorkerRun, error) {
+	return workerRunModel.Snapshots(ctx)
+}
+
+func Runs(ctx context.Context) ([]model.WorkerRun, error) {
+	return workerRunModel.List(ctx, 50)
+}
+
+func Checkpoints(ctx context.Context) ([]model.Checkpoint, error) {
+	return checkpointModel.List(ctx)
+}
+
+func Statuses() (statuses []Status) {
+	for _, worker := range workers() {
+		statuses = append(statuses, worker.base().Status())
+	}
+	return
+}
+
+func Find(workerType string) (*Worker, error) {
+	worker, err := lookup(workerType)
+	if err != nil {
+		return nil, err
+	}
+	return worker.base(), nil
+}
+
+func Fetch(ctx context.Context, workerType string, login string) error {
+	switch workerType {
+	case app.TypeUser:
+		return UserWorker.Pull(ctx, login)
+	case app.TypeOrganization:
+		return OrganizationWorker.Pull(ctx, login)
+	}
+	return ErrNotFound
+}
+
+func Refresh(ctx context.Context, workerType string) error {
+	worker, err := lookup(workerType)
+	if err != nil {
+		return err
+	}
+	if err := setup(ctx); err != nil {
+		return err
+	}
+	return refresh(ctx, worker)
+}
+
+func Rerank(ctx context.Context, workerType string, field string) error {
+	worker, err := lookup(workerType)
+	if err != nil {
+		return err
+	}
+	if field != "" && !hasField(worker, field) {
+		return ErrUnknownField
+	}
+	run, err := workerRunModel.Snapshot(ctx, workerType)
+	if err != nil {
+		return err
+	}
+	if run.Snapshot.IsZero() {
+		return ErrUnpublished
+	}
+
+	w := worker.base()
+	if err := w.cleanup(ctx); err != nil {
+		return err
+	}
+	if err := w.begin(ctx, CommandRank); err != nil {
+		return err
+	}
+	err = rerank(ctx, worker, field, run)
+	w.end(err)
+	return err
+}
+
+// rerank ranks the pipelines of the given field into a new snapshot, which keeps the published ranks of the other fields as they were created.
+func rerank(ctx context.Context, worker Interface, field string, published model.WorkerRun) error {
+	w := worker.base()
+	timestamp, err := w.stage(ctx)
+	if err != nil {
+		return err
+	}
+	discard := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := rankModel.Discard(ctx, timestamp, w.Type); err != nil {
 			logger.Error(err.Error())
 		}
-		collecting -= 1
-		if err != nil {
+	}
+
+	count := 0
+	for _, p := range worker.pipelines() {
+		if field != "" && p.Field != field {
+			continue
+		}
+		if err := rankModel.Store(ctx, worker.collection(), *p, timestamp); err != nil {
+			discard()
+			return err
+		}
+		count++
+	}
+	if field != "" {
+		w.run.Fields = nil
+		for _, f := range fields(worker) {
+			if f != field {
+				w.run.Fields = append(w.run.Fields, model.FieldSnapshot{Field: f, CreatedAt: published.CreatedAt(f)})
+			}
+		}
+	}
+	if err := w.publish(ctx, timestamp); err != nil {
+		discard()
+		return err
+	}
+	logger.Success(fmt.Sprintf("Executed %d %s rank pipelines!", count, w.Type))
+	return rankModel.Prune(ctx, w.Type, w.run.Timestamps())
+}
+
+func fields(worker Interface) (fields []string) {
+	seen := make(map[string]bool)
+	for _, p := range worker.pipelines() {
+		if !seen[p.Field] {
+			seen[p.Field] = true
+			fields = append(fields, p.Field)
+		}
+	}
+	return
+}
+
+func hasField(worker Interface, field string) bool {
+	for _, f := range fields(worker) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func Start(ctx context.Context) error {
+	if err := setup(ctx); err != nil {
+		return err
+	}
+
+	for _, worker := range workers() {
+		wg.Add(1)
+		go run(ctx, worker)
+	}
+	return nil
+}
+
+func Run(ctx context.Context, command string) error {
+	if err := setup(ctx); err != nil {
+		return err
+	}
+
+	for _, worker := range workers() {
+		if err := execute(ctx, worker, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Execute(ctx context.Context, workerType string, command string) error {
+	worker, err := lookup(workerType)
+	if err != nil {
+		return err
+	}
+	if err := setup(ctx); err != nil {
+		return err
+	}
+	return execute(ctx, worker, command)
+}
+
+func execute(ctx context.Context, worker Interface, command string) error {
+	if err := worker.base().load(ctx); err != nil {
+		return err
+	}
+	if command == CommandCollect {
+		if err := collect(ctx, worker); err != nil {
+			return err
+		}
+	}
+	return rank(ctx, worker)
+}
+
+func Wait() {
+	wg.Wait()
+}
+
+func setup(ctx context.Context) error {
+	if err := rankModel.CreateIndexes(ctx); err != nil {
+		return err
+	}
+	if err := workerRunModel.CreateIndexes(ctx); err != nil {
+		return err
+	}
+	if err := UserWorker.UserModel.Migrate(ctx); err != nil {
+		return err
+	}
+	if err := UserWorker.UserModel.CreateIndexes(ctx); err != nil {
+		return err
+	}
+	if err := OrganizationWorker.OrganizationModel.Migrate(ctx); err != nil {
+		return err
+	}
+	return OrganizationWorker.OrganizationModel.CreateIndexes(ctx)
+}
+
+func run(ctx context.Context, worker Interface) {
+	defer wg.Done()
+	w := worker.base()
+	if err := w.load(ctx); err != nil {
+		logger.Error(err.Error())
+	}
+
+	jobs := w.jobs(ctx, worker)
+	var retry <-chan time.Time
+	for {
+		next := jobs.Next()
+		w.update(func(s *Status) {
+			for _, job := range jobs {
+				switch job.Command {
+				case CommandCollect:
+					s.NextCollect = job.At
+				case CommandRank:
+					s.NextRank = job.At
+				case CommandRefresh:
+					s.NextRefresh = job.At
+				}
+			}
+		})
+		timer := time.NewTimer(next.At.Sub(w.now()))
+
+		command, scheduled := "", true
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Warning(fmt.Sprintf("Stopped %s worker!", w.Type))
 			return
+		case <-retry:
+			logger.Warning(fmt.Sprintf("Retrying %s worker...", w.Type))
+			command = CommandCollect
+		case <-timer.C:
+			command = next.Command
+			next.Reschedule(w.now(), jitter())
+		case command = <-w.commands:
+			scheduled = false
+		}
+		timer.Stop()
+		retry = nil
+
+		commands := jobs.Commands(command, scheduled, w.Status().Paused)
+		if len(commands) == 0 {
+			logger.Warning(fmt.Sprintf("Skipped scheduled %s worker run while paused!", w.Type))
+			continue
+		}
+	loop:
+		for _, command := range commands {
+			switch command {
+			case CommandCollect:
+				if err := collect(ctx, worker); err != nil {
+					retry = time.After(retryInterval)
+					break loop
+				}
+			case CommandRank:
+				rank(ctx, worker)
+			case CommandRefresh:
+				refresh(ctx, worker)
+			}
+		}
+	}
+}
+
+func (w *Worker) jobs(ctx context.Context, worker Interface) (jobs schedule.Jobs) {
+	commands := []string{CommandCollect, CommandRank}
+	if _, ok := worker.(refresher); ok {
+		commands = append(commands, CommandRefresh)
+	}
+	for _, command := range commands {
+		spec := viper.GetString(fmt.Sprintf("SCHEDULE_%s_%s", strings.ToUpper(w.Type), strings.ToUpper(command)))
+		if spec == "" && command == CommandCollect {
+			spec = defaultSchedule
+		}
+		if spec == "" {
+			continue
+		}
+		s, err := schedule.Parse(spec)
+		if err != nil {
+			logger.Error(err.Error())
+			if command != CommandCollect {
+				continue
+			}
+			s, _ = schedule.Parse(defaultSchedule)
+		}
+		job := &schedule.Job{
+			Command:  command,
+			Schedule: s,
+		}
+		w.start(ctx, job)
+		jobs = append(jobs, job)
+	}
+	// A collect is followed by a rank, unless the rank runs on its own schedule.
+	if len(jobs) == 1 || jobs[1].Command != CommandRank {
+		jobs[0].Then = CommandRank
+	}
+	return
+}
+
+func (w *Worker) start(ctx context.Context, job *schedule.Job) {
+	last, err := workerRunModel.Last(ctx, w.Type, job.Command)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	if missed := job.Start(last.StartedAt, w.now(), jitter()); !missed.IsZero() {
+		logger.Warning(fmt.Sprintf("Catching up missed %s %s run scheduled at %s...", w.Type, job.Command, missed.Format(time.RFC3339)))
+	}
+}
+
+// batch runs a batch query and returns the errors that only concern a single owner by its alias, so that the owner is skipped alone.
+func (w *Worker) batch(ctx context.Context, q *query.Batch) (map[string]json.RawMessage, map[string]response.Error, error) {
+	res := response.Owners{}
+	errs := map[string]response.Error{}
+	err := w.retry(ctx, func() error {
+		res = response.Owners{}
+		errs = map[string]response.Error{}
+		if err := w.source().Fetch(ctx, fmt.Sprint(q), &res); err != nil {
+			return err
+		}
+		if res.Message != "" {
+			return errors.New(res.Message)
+		}
+		var global []response.Error
+		for _, err := range res.Errors {
+			if _, retryable := Classify(err); !retryable && err.Alias() != "" {
+				errs[err.Alias()] = err
+				continue
+			}
+			global = append(global, err)
+		}
+		return graphQLError(global)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Data, errs, nil
+}
+
+func refreshLimit() int {
+	if n := viper.GetInt("REFRESH_LIMIT"); n > 0 {
+		return n
+	}
+	return 1000
+}
+
+func jitter() time.Duration {
+	return viper.GetDuration("SCHEDULE_JITTER")
+}
+
+func collect(ctx context.Context, worker Interface) error {
+	w := worker.base()
+	w.update(func(s *Status) {
+		s.State = StateCollecting
+		s.Processed = 0
+	})
+	defer w.update(func(s *Status) {
+		s.State = StateIdle
+	})
+	if err := w.begin(ctx, CommandCollect); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	err := worker.Collect(ctx)
+	w.end(err)
+	if err != nil && ctx.Err() == nil {
+		logger.Error(err.Error())
+		w.update(func(s *Status) {
+			s.LastError = err.Error()
+		})
+	}
+	return err
+}
+
+func refresh(ctx context.Context, worker Interface) error {
+	r, ok := worker.(refresher)
+	if !ok {
+		return ErrUnsupported
+	}
+	w := worker.base()
+	w.update(func(s *Status) {
+		s.State = StateRefreshing
+		s.Processed = 0
+	})
+	defer w.update(func(s *Status) {
+		s.State = StateIdle
+	})
+	if err := w.begin(ctx, CommandRefresh); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	err := r.Refresh(ctx)
+	w.end(err)
+	if err != nil && ctx.Err() == nil {
+		logger.Error(err.Error())
+		w.update(func(s *Status) {
+			s.LastError = err.Error()
+		})
+	}
+	return err
+}
+
+func supports(worker Interface, command string) bool {
+	switch command {
+	case CommandCollect, CommandRank:
+		return true
+	case CommandRefresh:
+		_, ok := worker.(refresher)
+		return ok
+	}
+	return false
+}
+
+func rank(ctx context.Context, worker Interface) error {
+	w := worker.base()
+	w.update(func(s *Status) {
+		s.State = StateRanking
+	})
+	defer w.update(func(s *Status) {
+		s.State = StateIdle
+	})
+	if err := w.begin(ctx, CommandRank); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	err := worker.Rank(ctx)
+	w.end(err)
+	if err != nil && ctx.Err() == nil {
+		logger.Error(err.Error())
+		w.update(func(s *Status) {
+			s.LastError = err.Error()
+		})
+	}
+	return err
+}
+
+func lookup(workerType string) (Interface, error) {
+	for _, worker := range workers() {
+		if worker.base().Type == workerType {
+			return worker, nil
 		}
-		worker.Rank()
+	}
+	return nil, ErrNotFound
+}
+
+func workers() []Interface {
+	return []Interface{
+		UserWorker,
+		OrganizationWorker,
+		RepositoryWorker,
+	}
+}
+
+func newWorker(workerType string) *Worker {
+	return &Worker{
+		Type:     workerType,
+		Since:    time.Date(2007, time.October, 1, 0, 0, 0, 0, time.UTC),
+		commands: make(chan string, 1),
 	}
 }
@@ -6,7 +6,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
 	"time"
 )
 
@@ -14,16 +13,17 @@ var (
 	client *mongo.Client
 )
 
-func Connect() {
+func Connect() (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var err error
 	opts := options.Client().ApplyURI(viper.GetString("DB_HOST"))
 	client, err = mongo.Connect(ctx, opts)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
+	return
+}
+
+func Disconnect(ctx context.Context) error {
+	return client.Disconnect(ctx)
 }
 
 func Database() *mongo.Database {
@@ -34,78 +34,53 @@ func Collection(name string) *mongo.Collection {
 	return Database().Collection(name)
 }
 
-func Count(collection string) int64 {
-	count, err := Collection(collection).CountDocuments(context.Background(), bson.D{})
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	return count
+func Count(ctx context.Context, collection string) (int64, error) {
+	return Collection(collection).CountDocuments(ctx, bson.D{})
 }
 
-func BulkWrite(collection string, models []mongo.WriteModel) *mongo.BulkWriteResult {
-	res, err := Collection(collection).BulkWrite(context.Background(), models)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	return res
+func BulkWrite(ctx context.Context, collection string, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	return Collection(collection).BulkWrite(ctx, models)
 }
 
-func Find(collection string, filter bson.D, opts ...*options.FindOptions) *mongo.Cursor {
-	cursor, err := Collection(collection).Find(context.Background(), filter, opts...)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	return cursor
+func Find(ctx context.Context, collection string, filter bson.D, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return Collection(collection).Find(ctx, filter, opts...)
 }
 
-func FindOne(collection string, filter bson.D, opts ...*options.FindOneOptions) *mongo.SingleResult {
-	return Collection(collection).FindOne(context.Background(), filter, opts...)
+func FindOne(ctx context.Context, collection string, filter bson.D, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return Collection(collection).FindOne(ctx, filter, opts...)
 }
 
-func UpdateOne(collection string, filter bson.D, update bson.D, opts ...*options.UpdateOptions) {
-	if _, err := Collection(collection).UpdateOne(context.Background(), filter, update, opts...); err != nil {
-		log.Fatal(err.Error())
-	}
+func UpdateOne(ctx context.Context, collection string, filter bson.D, update bson.D, opts ...*options.UpdateOptions) error {
+	_, err := Collection(collection).UpdateOne(ctx, filter, update, opts...)
+	return err
 }
 
-func UpdateMany(collection string, filter bson.D, update bson.D, opts ...*options.UpdateOptions) {
-	if _, err := Collection(collection).UpdateMany(context.Background(), filter, update, opts...); err != nil {
-		log.Fatal(err.Error())
-	}
+func UpdateMany(ctx context.Context, collection string, filter bson.D, update bson.D, opts ...*options.UpdateOptions) error {
+	_, err := Collection(collection).UpdateMany(ctx, filter, update, opts...)
+	return err
 }
 
-func DeleteMany(collection string, filter bson.D, opts ...*options.DeleteOptions) {
-	if _, err := Collection(collection).DeleteMany(context.Background(), filter, opts...); err != nil {
-		log.Fatal(err.Error())
-	}
+func DeleteMany(ctx context.Context, collection string, filter bson.D, opts ...*options.DeleteOptions) error {
+	_, err := Collection(collection).DeleteMany(ctx, filter, opts...)
+	return err
 }
 
-func All(ctx context.Context, collection string, skip int, limit int) *mongo.Cursor {
+func All(ctx context.Context, collection string, skip int, limit int) (*mongo.Cursor, error) {
 	opts := options.Find().SetBatchSize(1000).SetSkip(int64(skip)).SetLimit(int64(limit))
-	cursor, err := Collection(collection).Find(ctx, bson.D{}, opts)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	return cursor
+	return Collection(collection).Find(ctx, bson.D{}, opts)
 }
 
-func Aggregate(ctx context.Context, collection string, pipeline []bson.D) *mongo.Cursor {
+func Aggregate(ctx context.Context, collection string, pipeline []bson.D) (*mongo.Cursor, error) {
 	opts := options.Aggregate().SetBatchSize(1000).SetAllowDiskUse(true)
-	cursor, err := Collection(collection).Aggregate(ctx, pipeline, opts)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	return cursor
+	return Collection(collection).Aggregate(ctx, pipeline, opts)
 }
 
-func CloseCursor(ctx context.Context, cursor *mongo.Cursor) {
-	if err := cursor.Close(ctx); err != nil {
-		log.Fatal(err.Error())
-	}
+func CloseCursor(ctx context.Context, cursor *mongo.Cursor) error {
+	return cursor.Close(ctx)
 }
 
-func CreateIndexes(collection string, keys []string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func CreateIndexes(ctx context.Context, collection string, keys []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var models []mongo.IndexModel
@@ -115,13 +90,24 @@ func CreateIndexes(collection string, keys []string) {
 			Options: options.Index().SetName(key),
 		})
 	}
-	if _, err := Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
-		log.Fatal(err.Error())
+	_, err := Collection(collection).Indexes().CreateMany(ctx, models)
+	return err
+}
+
+func CreateCompoundIndex(ctx context.Context, collection string, name string, keys bson.D) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	model := mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(name),
 	}
+	_, err := Collection(collection).Indexes().CreateOne(ctx, model)
+	return err
 }
 
-func CreateUniqueIndexes(collection string, keys []string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func CreateUniqueIndexes(ctx context.Context, collection string, keys []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var models []mongo.IndexModel
@@ -131,7 +117,6 @@ func CreateUniqueIndexes(collection string, keys []string) {
 			Options: options.Index().SetUnique(true).SetName(key),
 		})
 	}
-	if _, err := Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
-		log.Fatal(err.Error())
-	}
+	_, err := Collection(collection).Indexes().CreateMany(ctx, models)
+	return err
 }
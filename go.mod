@@ -6,6 +6,7 @@ require (
 	github.com/go-playground/validator/v10 v10.4.1
 	github.com/gorilla/mux v1.8.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/viper v1.7.2-0.20201203004352-bba82cfc61da
 	go.mongodb.org/mongo-driver v1.4.4
 	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
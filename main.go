@@ -1,30 +1,230 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/memochou1993/gh-rankings/app/command"
 	"github.com/memochou1993/gh-rankings/app/handler"
 	"github.com/memochou1993/gh-rankings/app/worker"
 	"github.com/memochou1993/gh-rankings/database"
+	"github.com/memochou1993/gh-rankings/logger"
 	"github.com/memochou1993/gh-rankings/util"
+	"github.com/spf13/viper"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func init() {
 	util.LoadEnv()
-	database.Connect()
-	worker.Start()
+	if err := database.Connect(); err != nil {
+		log.Fatal(err.Error())
+	}
 }
 
+const (
+	modeServe   = "serve"
+	modeCollect = "collect"
+	modeRank    = "rank"
+	modeAll     = "all"
+	modeFetch   = "fetch"
+	modeRefresh = "refresh"
+	modeLocate  = "locate"
+	modeExport  = "export"
+	modeReplay  = "replay"
+)
+
+var (
+	once = flag.Bool("once", false, "collect and rank once, then exit")
+)
+
 func main() {
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		logger.Warning(fmt.Sprintf("Received %s signal, shutting down...", <-c))
+		cancel()
+	}()
+
+	var args []string
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
+	}
+
+	var err error
+	switch m := mode(); m {
+	case modeServe:
+		if err = unexpected(m, args); err == nil {
+			err = serve(ctx, false)
+		}
+	case modeCollect:
+		err = collect(ctx, args)
+	case modeRank:
+		err = withAdmin(false, func() error {
+			return command.Rank(ctx, args)
+		})
+	case modeAll:
+		if err = unexpected(m, args); err == nil {
+			err = serve(ctx, true)
+		}
+	case modeFetch:
+		err = command.Fetch(ctx, args)
+	case modeRefresh:
+		err = command.Refresh(ctx, args)
+	case modeLocate:
+		err = command.Locate(args)
+	case modeExport:
+		err = command.Export(ctx, args)
+	case modeReplay:
+		err = command.Replay(ctx, args)
+	default:
+		err = fmt.Errorf("unknown mode %q", m)
+	}
+
+	disconnectCtx, disconnectCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer disconnectCancel()
+	if err := database.Disconnect(disconnectCtx); err != nil {
+		logger.Error(err.Error())
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err.Error())
+	}
+	logger.Info("Stopped!")
+}
+
+func mode() string {
+	if flag.NArg() > 0 {
+		return flag.Arg(0)
+	}
+	if m := viper.GetString("APP_MODE"); m != "" {
+		return m
+	}
+	return modeAll
+}
+
+func serve(ctx context.Context, workers bool) error {
+	if workers {
+		if err := worker.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	srv := listen(":80", router(workers))
+	<-ctx.Done()
+	shutdown(srv)
+	worker.Wait()
+	return nil
+}
+
+// withAdmin serves the admin API on ADMIN_PORT, if set, while the given function runs.
+func withAdmin(scheduled bool, f func() error) error {
+	if port := viper.GetString("ADMIN_PORT"); port != "" {
+		r := mux.NewRouter()
+		workerRoutes(adminRoutes(r.PathPrefix("/api").Subrouter()), scheduled)
+		srv := listen(":"+port, r)
+		defer shutdown(srv)
+	}
+	return f()
+}
+
+func listen(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err.Error())
+		}
+	}()
+	return srv
+}
+
+func shutdown(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func unexpected(m string, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q for mode %q", args, m)
+	}
+	return nil
+}
+
+func collect(ctx context.Context, args []string) error {
+	f := flag.NewFlagSet(modeCollect, flag.ContinueOnError)
+	once := f.Bool("once", *once, "collect and rank once, then exit")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if err := unexpected(modeCollect, f.Args()); err != nil {
+		return err
+	}
+	if *once {
+		return withAdmin(false, func() error {
+			return worker.Run(ctx, worker.CommandCollect)
+		})
+	}
+
+	if err := worker.Start(ctx); err != nil {
+		return err
+	}
+	return withAdmin(true, func() error {
+		<-ctx.Done()
+		worker.Wait()
+		return nil
+	})
+}
+
+func router(workers bool) *mux.Router {
 	r := mux.NewRouter()
 	api := r.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/ranks", handler.ListRanks).Methods(http.MethodGet)
+	api.HandleFunc("/ranks/history", handler.ListRankHistory).Methods(http.MethodGet)
 	api.HandleFunc("/users", handler.ListUsers).Methods(http.MethodGet)
 	api.HandleFunc("/users/{login}", handler.ShowUser).Methods(http.MethodGet)
 	api.HandleFunc("/organizations", handler.ListOrganizations).Methods(http.MethodGet)
 	api.HandleFunc("/organizations/{login}", handler.ShowOrganization).Methods(http.MethodGet)
 	api.HandleFunc("/repositories", handler.ListRepositories).Methods(http.MethodGet)
 	api.HandleFunc("/repositories/{owner}/{name}", handler.ShowRepository).Methods(http.MethodGet)
-	log.Fatal(http.ListenAndServe(":80", r))
+	admin := adminRoutes(api)
+	if workers {
+		workerRoutes(admin, true)
+	}
+	return r
+}
+
+func adminRoutes(api *mux.Router) *mux.Router {
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(handler.Authenticate)
+	admin.HandleFunc("/checkpoints", handler.ListCheckpoints).Methods(http.MethodGet)
+	admin.HandleFunc("/runs", handler.ListRuns).Methods(http.MethodGet)
+	admin.HandleFunc("/snapshots", handler.ListSnapshots).Methods(http.MethodGet)
+	return admin
+}
+
+// workerRoutes registers the routes of the workers running in this process, which can only be triggered when they run on their schedules.
+func workerRoutes(admin *mux.Router, scheduled bool) {
+	admin.HandleFunc("/workers", handler.ListWorkers).Methods(http.MethodGet)
+	admin.HandleFunc("/workers/{type}/pause", handler.PauseWorker).Methods(http.MethodPost)
+	admin.HandleFunc("/workers/{type}/resume", handler.ResumeWorker).Methods(http.MethodPost)
+	if scheduled {
+		admin.HandleFunc("/workers/{type}/collect", handler.CollectWorker).Methods(http.MethodPost)
+		admin.HandleFunc("/workers/{type}/rank", handler.RankWorker).Methods(http.MethodPost)
+		admin.HandleFunc("/workers/{type}/refresh", handler.RefreshWorker).Methods(http.MethodPost)
+	}
 }
@@ -0,0 +1,55 @@
+package archive
+
+import (
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/query"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	path, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(path)
+
+	a := app.NewArchive(path)
+	q := query.OwnerRepositories()
+	q.OwnerArguments.Login = "memochou1993"
+	for _, b := range []string{
+		`{"data":{"rateLimit":{"cost":1}}}`,
+		`{"data":{"rateLimit":{"cost":2}}}`,
+	} {
+		if err := a.Write(q.String(), []byte(b)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	var entries []app.Entry
+	if err := a.Each(func(entry *app.Entry) error {
+		entries = append(entries, *entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected: %d, Actual: %d", 2, len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Cost != int64(i+1) {
+			t.Errorf("Expected: %d, Actual: %d", i+1, entry.Cost)
+		}
+		if name := entry.Operation(); name != "OwnerRepositories" {
+			t.Errorf("Expected: %s, Actual: %s", "OwnerRepositories", name)
+		}
+		variables := query.OwnerArguments{}
+		if err := entry.Variables(&variables); err != nil {
+			t.Fatal(err.Error())
+		}
+		if variables.Login != "memochou1993" {
+			t.Errorf("Expected: %s, Actual: %s", "memochou1993", variables.Login)
+		}
+	}
+}
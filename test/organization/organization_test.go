@@ -1,7 +1,7 @@
 package organization
 
 import (
-	"github.com/memochou1993/gh-rankings/app"
+	"context"
 	"github.com/memochou1993/gh-rankings/app/model"
 	"github.com/memochou1993/gh-rankings/app/query"
 	"github.com/memochou1993/gh-rankings/app/worker"
@@ -10,8 +10,8 @@ import (
 	"github.com/memochou1993/gh-rankings/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"log"
 	"os"
-	"strconv"
 	"testing"
 )
 
@@ -24,17 +24,19 @@ func TestMain(m *testing.M) {
 
 func setUp() {
 	util.LoadEnv()
-	database.Connect()
+	if err := database.Connect(); err != nil {
+		log.Fatal(err.Error())
+	}
 }
 
 func TestFetch(t *testing.T) {
 	o := worker.NewOrganizationWorker()
 
 	o.SearchQuery = query.Owners()
-	o.SearchQuery.SearchArguments.Query = strconv.Quote("created:2020-01-01..2020-01-01 repos:50..1000 sort:joined-asc")
+	o.SearchQuery.SearchArguments.Query = "created:2020-01-01..2020-01-01 repos:50..1000 sort:joined-asc"
 
 	var organizations []model.Organization
-	if err := o.Fetch(&organizations); err != nil {
+	if err := o.Fetch(context.Background(), &organizations); err != nil {
 		t.Error(err.Error())
 	}
 	if len(organizations) == 0 {
@@ -50,8 +52,10 @@ func TestStore(t *testing.T) {
 	organization := model.Organization{Login: "github"}
 	organizations := []model.Organization{organization}
 
-	o.OrganizationModel.Store(organizations)
-	res := database.FindOne(o.OrganizationModel.Name(), bson.D{{"_id", organization.ID()}})
+	if _, err := o.OrganizationModel.Store(context.Background(), organizations); err != nil {
+		t.Fatal(err.Error())
+	}
+	res := database.FindOne(context.Background(), o.OrganizationModel.Name(), bson.D{{"login", organization.ID()}})
 	if res.Err() == mongo.ErrNoDocuments {
 		t.Fail()
 	}
@@ -68,11 +72,10 @@ func TestFetchRepositories(t *testing.T) {
 	o := worker.NewUserWorker()
 
 	o.RepositoryQuery = query.OwnerRepositories()
-	o.RepositoryQuery.Type = app.TypeOrganization
-	o.RepositoryQuery.OwnerArguments.Login = strconv.Quote("facebook")
+	o.RepositoryQuery.OwnerArguments.Login = "facebook"
 
 	var repositories []model.Repository
-	if err := o.FetchRepositories(&repositories); err != nil {
+	if err := o.FetchRepositories(context.Background(), &repositories); err != nil {
 		t.Error(err.Error())
 	}
 	if len(repositories) == 0 {
@@ -0,0 +1,314 @@
+package pipeline
+
+import (
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/handler/request"
+	"github.com/memochou1993/gh-rankings/app/pipeline"
+	"github.com/memochou1993/gh-rankings/app/resource"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankRepositoryByTopic(t *testing.T) {
+	if len(resource.Topics) == 0 {
+		t.Fatal("Expected curated topics")
+	}
+	pipelines := topics(pipeline.RankRepository())
+	for _, field := range []string{"forks", "stargazers", "watchers"} {
+		for _, topic := range resource.Topics {
+			p, ok := pipelines[[2]string{field, topic.Name}]
+			if !ok {
+				t.Errorf("Field: %s, Topic: %s, Expected pipeline", field, topic.Name)
+				continue
+			}
+			if p.Type != app.TypeRepository || p.Language != "" || p.Location != "" || len(p.Slices) != 0 {
+				t.Errorf("Field: %s, Topic: %s, Unexpected slice: %+v", field, topic.Name, *p)
+			}
+			stages := *p.Pipeline
+			expected := bson.D{{"$match", bson.D{{"repository_topics.nodes.topic.name", topic.Name}}}}
+			if !reflect.DeepEqual(stages[0], expected) {
+				t.Errorf("Field: %s, Expected: %v, Actual: %v", field, expected, stages[0])
+			}
+			project := stage(stages, "$project").(bson.D).Map()
+			if project["_id"] != "$_id" || !reflect.DeepEqual(project["total_count"], bson.D{{"$sum", "$" + field + ".total_count"}}) {
+				t.Errorf("Field: %s, Unexpected projection: %v", field, project)
+			}
+		}
+	}
+	if len(pipelines) != 3*len(resource.Topics) {
+		t.Errorf("Expected: %d, Actual: %d", 3*len(resource.Topics), len(pipelines))
+	}
+}
+
+func TestRankOwnerRepositoryByTopic(t *testing.T) {
+	cases := []struct {
+		rankType  string
+		pipelines []*pipeline.Pipeline
+		fields    []string
+	}{
+		{
+			rankType:  app.TypeUser,
+			pipelines: pipeline.RankUser(),
+			fields:    []string{"repositories.stargazers", "contributed_repositories.stargazers"},
+		},
+		{
+			rankType:  app.TypeOrganization,
+			pipelines: pipeline.RankOrganization(),
+			fields:    []string{"repositories.forks", "repositories.stargazers", "repositories.watchers"},
+		},
+	}
+	for _, c := range cases {
+		pipelines := topics(c.pipelines)
+		for _, field := range c.fields {
+			path := field[:strings.LastIndex(field, ".")]
+			for _, topic := range resource.Topics {
+				p, ok := pipelines[[2]string{field, topic.Name}]
+				if !ok {
+					t.Errorf("Type: %s, Field: %s, Topic: %s, Expected pipeline", c.rankType, field, topic.Name)
+					continue
+				}
+				if p.Type != c.rankType || p.Language != "" || p.Location != "" || len(p.Slices) != 0 {
+					t.Errorf("Type: %s, Field: %s, Unexpected slice: %+v", c.rankType, field, *p)
+				}
+				stages := *p.Pipeline
+				if unwind := stage(stages, "$unwind"); unwind != "$"+path {
+					t.Errorf("Type: %s, Field: %s, Expected: %s, Actual: %v", c.rankType, field, "$"+path, unwind)
+				}
+				match := stage(stages[1:], "$match")
+				expected := bson.D{{path + ".repository_topics.nodes.topic.name", topic.Name}}
+				if !reflect.DeepEqual(match, expected) {
+					t.Errorf("Type: %s, Field: %s, Expected: %v, Actual: %v", c.rankType, field, expected, match)
+				}
+				group := stage(stages, "$group").(bson.D).Map()
+				if group["_id"] != "$_id" || !reflect.DeepEqual(group["total_count"], bson.D{{"$sum", "$" + field + ".total_count"}}) {
+					t.Errorf("Type: %s, Field: %s, Unexpected group: %v", c.rankType, field, group)
+				}
+			}
+		}
+		if len(pipelines) != len(c.fields)*len(resource.Topics) {
+			t.Errorf("Type: %s, Expected: %d, Actual: %d", c.rankType, len(c.fields)*len(resource.Topics), len(pipelines))
+		}
+	}
+}
+
+func TestRankRepositoryByLocation(t *testing.T) {
+	cases := map[string]struct {
+		projected bool
+		id        bson.D
+	}{
+		pipeline.SliceLocation: {
+			projected: false,
+			id:        bson.D{{pipeline.SliceLocation, "$location"}},
+		},
+		pipeline.SliceLocation + "," + pipeline.SliceLanguage: {
+			projected: true,
+			id:        bson.D{{pipeline.SliceLocation, "$location"}, {pipeline.SliceLanguage, "$language"}},
+		},
+	}
+	n := 0
+	for _, p := range pipeline.RankRepository() {
+		if len(p.Slices) == 0 || p.Topic != "" {
+			continue
+		}
+		n++
+		c, ok := cases[strings.Join(p.Slices, ",")]
+		if !ok {
+			t.Errorf("Field: %s, Unexpected slices: %v", p.Field, p.Slices)
+			continue
+		}
+		project := stage(*p.Pipeline, "$project").(bson.D).Map()
+		if _, ok := project[pipeline.SliceLanguage]; ok != c.projected {
+			t.Errorf("Field: %s, Slices: %v, Expected language projected: %t", p.Field, p.Slices, c.projected)
+		}
+		group := stage(pipeline.RankCount(*p), "$group").(bson.D).Map()
+		if !reflect.DeepEqual(group["_id"], c.id) {
+			t.Errorf("Field: %s, Expected: %v, Actual: %v", p.Field, c.id, group["_id"])
+		}
+	}
+	if n != 3*len(cases) {
+		t.Errorf("Expected: %d, Actual: %d", 3*len(cases), n)
+	}
+}
+
+func TestRankRepositoryByTopicAndLocation(t *testing.T) {
+	pipelines := map[[2]string]*pipeline.Pipeline{}
+	for _, p := range pipeline.RankRepository() {
+		if p.Topic != "" && len(p.Slices) > 0 {
+			pipelines[[2]string{p.Field, p.Topic}] = p
+		}
+	}
+	for _, field := range []string{"forks", "stargazers", "watchers"} {
+		for _, topic := range resource.Topics {
+			p, ok := pipelines[[2]string{field, topic.Name}]
+			if !ok {
+				t.Errorf("Field: %s, Topic: %s, Expected pipeline", field, topic.Name)
+				continue
+			}
+			if !reflect.DeepEqual(p.Slices, []string{pipeline.SliceLocation}) {
+				t.Errorf("Field: %s, Topic: %s, Unexpected slices: %v", field, topic.Name, p.Slices)
+			}
+			stages := *p.Pipeline
+			expected := bson.D{{"$match", bson.D{{"repository_topics.nodes.topic.name", topic.Name}}}}
+			if !reflect.DeepEqual(stages[0], expected) {
+				t.Errorf("Field: %s, Expected: %v, Actual: %v", field, expected, stages[0])
+			}
+			if unwind := stage(stages, "$unwind"); unwind != "$"+pipeline.SliceLocation {
+				t.Errorf("Field: %s, Expected: %s, Actual: %v", field, "$"+pipeline.SliceLocation, unwind)
+			}
+			group := stage(pipeline.RankCount(*p), "$group").(bson.D).Map()
+			if id := (bson.D{{pipeline.SliceLocation, "$location"}}); !reflect.DeepEqual(group["_id"], id) {
+				t.Errorf("Field: %s, Expected: %v, Actual: %v", field, id, group["_id"])
+			}
+		}
+	}
+	if len(pipelines) != 3*len(resource.Topics) {
+		t.Errorf("Expected: %d, Actual: %d", 3*len(resource.Topics), len(pipelines))
+	}
+}
+
+func TestRankCount(t *testing.T) {
+	p := pipeline.RankRepository()[0]
+	stages := pipeline.RankCount(*p)
+	if len(stages) != len(*p.Pipeline)+2 {
+		t.Fatalf("Expected: %d, Actual: %d", len(*p.Pipeline)+2, len(stages))
+	}
+	group := stage(stages, "$group").(bson.D).Map()
+	if !reflect.DeepEqual(group["_id"], bson.D{}) {
+		t.Errorf("Expected unsliced pipeline to be counted as a whole, Actual: %v", group["_id"])
+	}
+	if stage(*p.Pipeline, "$group") != nil {
+		t.Error("Expected pipeline to be left unchanged")
+	}
+}
+
+func TestRankOwnerRepositoryByLanguageAndLocation(t *testing.T) {
+	defer viper.Reset()
+	cases := []struct {
+		languages string
+		locations string
+		expected  [2][]string
+	}{
+		{
+			languages: "Go;JavaScript",
+			locations: "Taiwan;Germany",
+			expected:  [2][]string{{"Go", "JavaScript"}, {"Taiwan", "Germany"}},
+		},
+		{
+			languages: " Go ;; JavaScript ;",
+			locations: "Taipei, Taiwan ; ",
+			expected:  [2][]string{{"Go", "JavaScript"}, {"Taipei, Taiwan"}},
+		},
+		{
+			languages: "",
+			locations: "Taiwan",
+		},
+		{
+			languages: "Go",
+			locations: " ; ",
+		},
+	}
+	for _, c := range cases {
+		viper.Set("RANK_LANGUAGES", c.languages)
+		viper.Set("RANK_LOCATIONS", c.locations)
+		for rankType, pipelines := range map[string][]*pipeline.Pipeline{
+			app.TypeUser:         pipeline.RankUser(),
+			app.TypeOrganization: pipeline.RankOrganization(),
+		} {
+			var sliced []*pipeline.Pipeline
+			for _, p := range pipelines {
+				if len(p.Slices) > 0 {
+					sliced = append(sliced, p)
+				}
+			}
+			if c.expected[0] == nil {
+				if len(sliced) > 0 {
+					t.Errorf("Type: %s, Languages: %q, Locations: %q, Expected pipelines to be skipped", rankType, c.languages, c.locations)
+				}
+				continue
+			}
+			if len(sliced) != 3 {
+				t.Errorf("Type: %s, Expected: %d, Actual: %d", rankType, 3, len(sliced))
+			}
+			for _, p := range sliced {
+				if !reflect.DeepEqual(p.Slices, []string{pipeline.SliceLocation, pipeline.SliceLanguage}) {
+					t.Errorf("Type: %s, Unexpected slices: %v", rankType, p.Slices)
+				}
+				path := p.Field[:strings.LastIndex(p.Field, ".")]
+				for i, field := range []string{path + ".primary_language.name", pipeline.SliceLocation} {
+					expected := bson.D{{"$in", c.expected[i]}}
+					if actual := match(*p.Pipeline, field); !reflect.DeepEqual(actual, expected) {
+						t.Errorf("Type: %s, Field: %s, Expected: %v, Actual: %v", rankType, field, expected, actual)
+					}
+				}
+				project := stage(*p.Pipeline, "$project").(bson.D).Map()
+				for key, expected := range map[string]interface{}{
+					"_id":                  "$_id.login",
+					pipeline.SliceLanguage: "$_id.language",
+					pipeline.SliceLocation: 1,
+				} {
+					if actual := project[key]; actual != expected {
+						t.Errorf("Type: %s, Key: %s, Expected: %v, Actual: %v", rankType, key, expected, actual)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestListRanks(t *testing.T) {
+	createdAt := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	req := &request.Rank{
+		Page:  1,
+		Limit: 10,
+		Snapshots: []request.Snapshot{
+			{Type: app.TypeRepository, CreatedAt: createdAt.AddDate(0, 0, 1)},
+			{Type: app.TypeRepository, Fields: []string{"forks", "watchers"}, CreatedAt: createdAt},
+		},
+	}
+	expected := bson.D{{"$or", bson.A{
+		bson.D{{"type", app.TypeRepository}, {"created_at", createdAt.AddDate(0, 0, 1)}},
+		bson.D{{"type", app.TypeRepository}, {"created_at", createdAt}, {"field", bson.D{{"$in", []string{"forks", "watchers"}}}}},
+	}}}
+	for _, p := range []mongo.Pipeline{pipeline.ListRanks(req), pipeline.SearchRanks(req)} {
+		cond := stage(p, "$match").(bson.D)[0].Value.(mongo.Pipeline)
+		if actual := cond[len(cond)-1]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected: %v, Actual: %v", expected, actual)
+		}
+	}
+}
+
+func topics(pipelines []*pipeline.Pipeline) map[[2]string]*pipeline.Pipeline {
+	m := map[[2]string]*pipeline.Pipeline{}
+	for _, p := range pipelines {
+		if p.Topic != "" && len(p.Slices) == 0 {
+			m[[2]string{p.Field, p.Topic}] = p
+		}
+	}
+	return m
+}
+
+func match(stages []bson.D, field string) interface{} {
+	for _, s := range stages {
+		if s[0].Key != "$match" {
+			continue
+		}
+		if cond := s[0].Value.(bson.D); cond[0].Key == field {
+			return cond[0].Value
+		}
+	}
+	return nil
+}
+
+func stage(stages []bson.D, operator string) interface{} {
+	for _, s := range stages {
+		if s[0].Key == operator {
+			return s[0].Value
+		}
+	}
+	return nil
+}
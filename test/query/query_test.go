@@ -0,0 +1,156 @@
+package query
+
+import (
+	"encoding/json"
+	"github.com/memochou1993/gh-rankings/app/query"
+	"strings"
+	"testing"
+	"time"
+)
+
+type payload struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+func TestOwners(t *testing.T) {
+	q := query.Owners()
+	q.SearchArguments.SetQuery(query.SearchSpecifiedUser(`memo"chou`))
+	q.SearchArguments.After = "Y3Vyc29yOjEwMA=="
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	if s := "search(after: $after, first: $first, query: $query, type: $type)"; !strings.Contains(p.Query, s) {
+		t.Errorf("Expected query to contain %s", s)
+	}
+	for name, expected := range map[string]interface{}{
+		"after": "Y3Vyc29yOjEwMA==",
+		"first": float64(100),
+		"query": `user:memo"chou`,
+		"type":  "USER",
+	} {
+		if actual := p.Variables[name]; actual != expected {
+			t.Errorf("Variable: %s, Expected: %v, Actual: %v", name, expected, actual)
+		}
+	}
+}
+
+func TestOwnerRepositories(t *testing.T) {
+	q := query.OwnerRepositories()
+	q.OwnerArguments.Login = "memochou1993"
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := p.Variables["after"]; ok {
+		t.Error("Expected empty cursor to be omitted")
+	}
+	if p.Variables["login"] != "memochou1993" {
+		t.Fail()
+	}
+	orderBy, ok := p.Variables["orderBy"].(map[string]interface{})
+	if !ok || orderBy["field"] != "CREATED_AT" || orderBy["direction"] != "ASC" {
+		t.Errorf("Unexpected orderBy: %v", p.Variables["orderBy"])
+	}
+}
+
+func TestOwnersByLogin(t *testing.T) {
+	q := query.OwnersByLogin([]string{"memochou1993", "github"})
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, s := range []string{
+		`query OwnersByLogin($o0: String!, $o1: String!)`,
+		`o0: repositoryOwner(login: $o0) { ...owner }`,
+		`o1: repositoryOwner(login: $o1) { ...owner }`,
+		`fragment rateLimit on RateLimit`,
+	} {
+		if !strings.Contains(p.Query, s) {
+			t.Errorf("Expected query to contain %s", s)
+		}
+	}
+	if p.Variables["o0"] != "memochou1993" || p.Variables["o1"] != "github" {
+		t.Errorf("Unexpected variables: %v", p.Variables)
+	}
+}
+
+func TestOwnersByNodeID(t *testing.T) {
+	q := query.OwnersByLogin([]string{"memochou1993", "github"})
+	q.NodeIDs = []string{"MDQ6VXNlcjE=", ""}
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, s := range []string{
+		`query OwnersByLogin($o0: ID!, $o1: String!)`,
+		`o0: node(id: $o0) { ...owner }`,
+		`o1: repositoryOwner(login: $o1) { ...owner }`,
+	} {
+		if !strings.Contains(p.Query, s) {
+			t.Errorf("Expected query to contain %s", s)
+		}
+	}
+	if p.Variables["o0"] != "MDQ6VXNlcjE=" || p.Variables["o1"] != "github" {
+		t.Errorf("Unexpected variables: %v", p.Variables)
+	}
+}
+
+func TestOwnerResources(t *testing.T) {
+	q := query.OwnerResources("organization", []string{"github"})
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, s := range []string{
+		`query OrganizationResources($o0: String!)`,
+		`o0: organization(login: $o0) { ...resources }`,
+	} {
+		if !strings.Contains(p.Query, s) {
+			t.Errorf("Expected query to contain %s", s)
+		}
+	}
+}
+
+func TestUserResources(t *testing.T) {
+	from := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	q := query.OwnerResources("user", []string{"memochou1993"})
+	q.ContributionsArguments = query.Contributions(from, from.AddDate(1, 0, 0))
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, s := range []string{
+		`query UserResources($o0: String!, $from: DateTime!, $to: DateTime!)`,
+		`contributionsCollection(from: $from, to: $to)`,
+	} {
+		if !strings.Contains(p.Query, s) {
+			t.Errorf("Expected query to contain %s", s)
+		}
+	}
+	if p.Variables["from"] != "2020-01-01T00:00:00Z" || p.Variables["to"] != "2021-01-01T00:00:00Z" {
+		t.Errorf("Unexpected variables: %v", p.Variables)
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	q := query.Repositories()
+
+	p := payload{}
+	if err := json.Unmarshal([]byte(q.String()), &p); err != nil {
+		t.Fatal(err.Error())
+	}
+	if s := "repositoryTopics(first: 10)"; !strings.Contains(p.Query, s) {
+		t.Errorf("Expected query to contain %s", s)
+	}
+	if p.Variables["type"] != "REPOSITORY" {
+		t.Errorf("Unexpected variables: %v", p.Variables)
+	}
+}
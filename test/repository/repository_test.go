@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/pipeline"
 	"github.com/memochou1993/gh-rankings/app/query"
 	"github.com/memochou1993/gh-rankings/app/worker"
 	"github.com/memochou1993/gh-rankings/database"
@@ -9,8 +12,8 @@ import (
 	"github.com/memochou1993/gh-rankings/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"log"
 	"os"
-	"strconv"
 	"testing"
 )
 
@@ -23,17 +26,19 @@ func TestMain(m *testing.M) {
 
 func setUp() {
 	util.LoadEnv()
-	database.Connect()
+	if err := database.Connect(); err != nil {
+		log.Fatal(err.Error())
+	}
 }
 
 func TestFetch(t *testing.T) {
 	r := worker.NewRepositoryWorker()
 
 	r.SearchQuery = query.Repositories()
-	r.SearchQuery.SearchArguments.Query = strconv.Quote("created:2020-01-01..2020-01-01 fork:true sort:stars stars:100..*")
+	r.SearchQuery.SearchArguments.Query = "created:2020-01-01..2020-01-01 fork:true sort:stars stars:100..*"
 
 	var repositories []model.Repository
-	if err := r.Fetch(&repositories); err != nil {
+	if err := r.Fetch(context.Background(), &repositories); err != nil {
 		t.Error(err.Error())
 	}
 	if len(repositories) == 0 {
@@ -49,8 +54,10 @@ func TestStore(t *testing.T) {
 	repository := model.Repository{NameWithOwner: "memochou1993/gh-rankings"}
 	repositories := []model.Repository{repository}
 
-	r.RepositoryModel.Store(repositories)
-	res := database.FindOne(r.RepositoryModel.Name(), bson.D{{"_id", repository.ID()}})
+	if _, err := r.RepositoryModel.Store(context.Background(), repositories); err != nil {
+		t.Fatal(err.Error())
+	}
+	res := database.FindOne(context.Background(), r.RepositoryModel.Name(), bson.D{{"_id", repository.ID()}})
 	if res.Err() == mongo.ErrNoDocuments {
 		t.Fail()
 	}
@@ -63,6 +70,61 @@ func TestStore(t *testing.T) {
 	test.DropCollection(r.RepositoryModel)
 }
 
+func TestRankByTopicAndLocation(t *testing.T) {
+	r := worker.NewRepositoryWorker()
+	u := worker.NewUserWorker()
+
+	ctx := context.Background()
+	user := model.User{Login: "memochou1993", Location: "Taipei, Taiwan"}
+	if _, err := u.UserModel.Store(ctx, []model.User{user}); err != nil {
+		t.Fatal(err.Error())
+	}
+	var repositories []model.Repository
+	b := []byte(`[
+		{"nameWithOwner":"memochou1993/foo","owner":{"login":"memochou1993"},"repositoryTopics":{"nodes":[{"topic":{"name":"kubernetes"}}]},"stargazers":{"totalCount":1}},
+		{"nameWithOwner":"memochou1993/bar","owner":{"login":"memochou1993"},"repositoryTopics":{"nodes":[]},"stargazers":{"totalCount":2}}
+	]`)
+	if err := json.Unmarshal(b, &repositories); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := r.RepositoryModel.Store(ctx, repositories); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var p *pipeline.Pipeline
+	for _, rank := range pipeline.RankRepository() {
+		if rank.Field == "stargazers" && rank.Topic == "kubernetes" && len(rank.Slices) > 0 {
+			p = rank
+		}
+	}
+	if p == nil {
+		t.Fatal("Expected pipeline")
+	}
+	cursor, err := database.Aggregate(ctx, r.RepositoryModel.Name(), *p.Pipeline)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var recs []struct {
+		ID         string `bson:"_id"`
+		TotalCount int    `bson:"total_count"`
+		Location   string `bson:"location"`
+	}
+	if err := cursor.All(ctx, &recs); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 records, Actual: %d", len(recs))
+	}
+	for i, location := range []string{"Taipei, Taiwan", "Taiwan"} {
+		if rec := recs[i]; rec.ID != "memochou1993/foo" || rec.Location != location || rec.TotalCount != 1 {
+			t.Errorf("Expected: memochou1993/foo %s 1, Actual: %+v", location, rec)
+		}
+	}
+
+	test.DropCollection(r.RepositoryModel)
+	test.DropCollection(u.UserModel)
+}
+
 func tearDown() {
 	test.DropDatabase()
 }
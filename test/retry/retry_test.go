@@ -0,0 +1,106 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/response"
+	"github.com/memochou1993/gh-rankings/app/worker"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "unauthorized", err: &app.ResponseError{StatusCode: 401, Remaining: -1}, retryable: false},
+		{name: "bad gateway", err: &app.ResponseError{StatusCode: 502, Remaining: -1}, retryable: true},
+		{name: "secondary rate limit", err: &app.ResponseError{StatusCode: 403, Remaining: -1, RetryAfter: time.Minute, ResetAt: time.Now().Add(time.Minute)}, retryable: true},
+		{name: "forbidden", err: &app.ResponseError{StatusCode: 403, Remaining: -1}, retryable: false},
+		{name: "rate limited", err: response.Error{Type: "RATE_LIMITED"}, retryable: true},
+		{name: "not found", err: fmt.Errorf("%w: %s", worker.ErrSkipped, "Could not resolve to a User"), retryable: false},
+		{name: "network", err: errors.New("connection reset by peer"), retryable: true},
+	}
+	for _, c := range cases {
+		if _, retryable := worker.Classify(c.err); retryable != c.retryable {
+			t.Errorf("Test: %s, Expected: %t, Actual: %t", c.name, c.retryable, retryable)
+		}
+	}
+
+	rateLimited := &app.ResponseError{StatusCode: 403, Remaining: 0, ResetAt: time.Now().Add(time.Hour)}
+	if delay, _ := worker.Classify(rateLimited); delay != worker.Immediately {
+		t.Errorf("Expected immediate retry with another token, Actual: %s", delay)
+	}
+
+	tokens := app.Tokens()
+	for i := 0; i < tokens.Len(); i++ {
+		token, err := tokens.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		tokens.Update(token, 5000, 0, time.Now().Add(time.Hour))
+	}
+	if delay, _ := worker.Classify(rateLimited); delay < 59*time.Minute {
+		t.Errorf("Expected delay until reset, Actual: %s", delay)
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := worker.RetryPolicy{
+		Attempts:    3,
+		Interval:    time.Millisecond,
+		MaxInterval: 4 * time.Millisecond,
+	}
+
+	attempts := 0
+	err := p.Do(context.Background(), func() error {
+		attempts++
+		return &app.ResponseError{StatusCode: 502, Remaining: -1}
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("Expected: %d, Actual: %d", 3, attempts)
+	}
+
+	attempts = 0
+	err = p.Do(context.Background(), func() error {
+		attempts++
+		return fmt.Errorf("%w: %s", worker.ErrSkipped, "deleted")
+	})
+	if !errors.Is(err, worker.ErrSkipped) || attempts != 1 {
+		t.Errorf("Expected: %d, Actual: %d", 1, attempts)
+	}
+
+	attempts = 0
+	err = p.Do(context.Background(), func() error {
+		attempts++
+		return &app.ResponseError{StatusCode: 401, Remaining: -1}
+	})
+	var resErr *app.ResponseError
+	if !errors.Is(err, worker.ErrSkipped) || !errors.As(err, &resErr) || attempts != 1 {
+		t.Errorf("Expected unretried error to be skipped, Actual: %v", err)
+	}
+
+	err = p.Do(context.Background(), func() error {
+		return response.Error{Type: "FORBIDDEN", Message: "Resource protected by organization SAML enforcement."}
+	})
+	if !errors.Is(err, worker.ErrSkipped) {
+		t.Errorf("Expected forbidden error to be skipped, Actual: %v", err)
+	}
+
+	err = p.Do(context.Background(), func() error {
+		return &app.ResponseError{StatusCode: 502, Remaining: -1}
+	})
+	if errors.Is(err, worker.ErrSkipped) {
+		t.Error("Expected exhausted retries to abort")
+	}
+
+	for attempt := 1; attempt < 10; attempt++ {
+		if d := p.Backoff(attempt); d > p.MaxInterval {
+			t.Errorf("Expected backoff within %s, Actual: %s", p.MaxInterval, d)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package schedule
+
+import (
+	"github.com/memochou1993/gh-rankings/app/schedule"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJobStart(t *testing.T) {
+	now := time.Date(2021, time.January, 5, 10, 0, 0, 0, time.UTC)
+	tomorrow := time.Date(2021, time.January, 6, 0, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		spec   string
+		last   time.Time
+		jitter time.Duration
+		at     time.Time
+		missed time.Time
+	}{
+		"never ran": {
+			spec: "0 0 * * *",
+			at:   now,
+		},
+		"ran on schedule": {
+			spec: "0 0 * * *",
+			last: time.Date(2021, time.January, 5, 0, 0, 0, 0, time.UTC),
+			at:   tomorrow,
+		},
+		"due now": {
+			spec: "0 10 * * *",
+			last: time.Date(2021, time.January, 4, 10, 0, 0, 0, time.UTC),
+			at:   now,
+		},
+		"missed while down": {
+			spec:   "0 0 * * *",
+			last:   time.Date(2021, time.January, 2, 0, 0, 0, 0, time.UTC),
+			jitter: 5 * time.Minute,
+			at:     now,
+			missed: time.Date(2021, time.January, 3, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for name, c := range cases {
+		job := &schedule.Job{Schedule: parse(t, c.spec)}
+		missed := job.Start(c.last, now, c.jitter)
+		if !job.At.Equal(c.at) {
+			t.Errorf("%s: Expected: %s, Actual: %s", name, c.at, job.At)
+		}
+		if !missed.Equal(c.missed) {
+			t.Errorf("%s: Expected missed: %s, Actual: %s", name, c.missed, missed)
+		}
+	}
+}
+
+func TestJobJitter(t *testing.T) {
+	now := time.Date(2021, time.January, 5, 10, 0, 0, 0, time.UTC)
+	tomorrow := time.Date(2021, time.January, 6, 0, 0, 0, 0, time.UTC)
+	jitter := 5 * time.Minute
+	for i := 0; i < 100; i++ {
+		job := &schedule.Job{Schedule: parse(t, "0 0 * * *")}
+		job.Start(time.Date(2021, time.January, 5, 0, 0, 0, 0, time.UTC), now, jitter)
+		if job.At.Before(tomorrow) || !job.At.Before(tomorrow.Add(jitter)) {
+			t.Fatalf("Expected: %s within %s, Actual: %s", tomorrow, jitter, job.At)
+		}
+		job.Reschedule(tomorrow.Add(time.Minute), jitter)
+		if next := tomorrow.AddDate(0, 0, 1); job.At.Before(next) || !job.At.Before(next.Add(jitter)) {
+			t.Fatalf("Expected: %s within %s, Actual: %s", next, jitter, job.At)
+		}
+	}
+}
+
+func TestJobsNext(t *testing.T) {
+	now := time.Date(2021, time.January, 5, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		at       []time.Duration
+		expected string
+	}{
+		{
+			at:       []time.Duration{time.Hour},
+			expected: "collect",
+		},
+		{
+			at:       []time.Duration{time.Hour, time.Minute, 2 * time.Minute},
+			expected: "rank",
+		},
+		{
+			at:       []time.Duration{time.Hour, time.Hour, time.Minute},
+			expected: "refresh",
+		},
+		{
+			at:       []time.Duration{time.Hour, time.Hour, time.Hour},
+			expected: "collect",
+		},
+	}
+	for _, c := range cases {
+		var jobs schedule.Jobs
+		for i, d := range c.at {
+			jobs = append(jobs, &schedule.Job{Command: []string{"collect", "rank", "refresh"}[i], At: now.Add(d)})
+		}
+		if next := jobs.Next(); next.Command != c.expected {
+			t.Errorf("At: %v, Expected: %s, Actual: %s", c.at, c.expected, next.Command)
+		}
+	}
+}
+
+func TestJobsCommands(t *testing.T) {
+	jobs := schedule.Jobs{
+		{Command: "collect", Then: "rank"},
+		{Command: "refresh"},
+	}
+	cases := []struct {
+		command   string
+		scheduled bool
+		paused    bool
+		expected  []string
+	}{
+		{command: "collect", scheduled: true, expected: []string{"collect", "rank"}},
+		{command: "collect", scheduled: true, paused: true},
+		{command: "collect", paused: true, expected: []string{"collect", "rank"}},
+		{command: "rank", expected: []string{"rank"}},
+		{command: "refresh", scheduled: true, expected: []string{"refresh"}},
+		{command: "refresh", scheduled: true, paused: true},
+	}
+	for _, c := range cases {
+		if actual := jobs.Commands(c.command, c.scheduled, c.paused); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Command: %s, Scheduled: %t, Paused: %t, Expected: %v, Actual: %v", c.command, c.scheduled, c.paused, c.expected, actual)
+		}
+	}
+}
+
+func parse(t *testing.T, spec string) *schedule.Schedule {
+	s, err := schedule.Parse(spec)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s
+}
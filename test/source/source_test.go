@@ -0,0 +1,43 @@
+package source
+
+import (
+	"context"
+	"github.com/memochou1993/gh-rankings/app"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixtureSource(t *testing.T) {
+	path, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(path)
+
+	q := `{"query":"query Owners { rateLimit { remaining } }"}`
+	b := []byte(`{"data":{"rateLimit":{"remaining":4999}}}`)
+	if err := ioutil.WriteFile(filepath.Join(path, app.FixtureName(q)), b, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res := struct {
+		Data struct {
+			RateLimit struct {
+				Remaining int64 `json:"remaining"`
+			} `json:"rateLimit"`
+		} `json:"data"`
+	}{}
+	s := app.NewFixtureSource(path)
+	if err := s.Fetch(context.Background(), q, &res); err != nil {
+		t.Error(err.Error())
+	}
+	if res.Data.RateLimit.Remaining != 4999 {
+		t.Fail()
+	}
+
+	if err := s.Fetch(context.Background(), `{"query":"query Unknown {}"}`, &res); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+package token
+
+import (
+	"context"
+	"github.com/memochou1993/gh-rankings/app"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	p := app.NewTokenPool([]string{"foo", "bar"})
+
+	foo, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p.Update(foo, 5000, 10, time.Now().Add(time.Hour))
+
+	bar, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if bar.Value == foo.Value {
+		t.Error("Expected the token with the most headroom")
+	}
+}
+
+func TestAcquireExhausted(t *testing.T) {
+	p := app.NewTokenPool([]string{"foo", "bar"})
+
+	foo, _ := p.Acquire(context.Background())
+	p.Update(foo, 5000, 0, time.Now().Add(time.Hour))
+	bar, _ := p.Acquire(context.Background())
+	p.Update(bar, 5000, 0, time.Now().Add(time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	token, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if token.Value != bar.Value {
+		t.Error("Expected the token with the earliest reset")
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	p := app.NewTokenPool([]string{"foo", "bar"})
+
+	foo, _ := p.Acquire(context.Background())
+	p.Update(foo, 5000, 0, time.Now().Add(time.Hour))
+	if _, exhausted := p.Exhausted(); exhausted {
+		t.Error("Expected a token with headroom")
+	}
+
+	resetAt := time.Now().Add(time.Minute)
+	bar, _ := p.Acquire(context.Background())
+	p.Update(bar, 5000, 0, resetAt)
+	if actual, exhausted := p.Exhausted(); !exhausted || !actual.Equal(resetAt) {
+		t.Errorf("Expected: %s, Actual: %s", resetAt, actual)
+	}
+}
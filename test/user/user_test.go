@@ -1,17 +1,21 @@
 package user
 
 import (
+	"context"
+	"fmt"
 	"github.com/memochou1993/gh-rankings/app"
 	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/pipeline"
 	"github.com/memochou1993/gh-rankings/app/query"
 	"github.com/memochou1993/gh-rankings/app/worker"
 	"github.com/memochou1993/gh-rankings/database"
 	"github.com/memochou1993/gh-rankings/test"
 	"github.com/memochou1993/gh-rankings/util"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"log"
 	"os"
-	"strconv"
 	"testing"
 )
 
@@ -24,17 +28,19 @@ func TestMain(m *testing.M) {
 
 func setUp() {
 	util.LoadEnv()
-	database.Connect()
+	if err := database.Connect(); err != nil {
+		log.Fatal(err.Error())
+	}
 }
 
 func TestFetch(t *testing.T) {
 	u := worker.NewUserWorker()
 
 	u.SearchQuery = query.Owners()
-	u.SearchQuery.SearchArguments.Query = strconv.Quote("created:2020-01-01..2020-01-01 followers:100..* sort:joined-asc")
+	u.SearchQuery.SearchArguments.Query = "created:2020-01-01..2020-01-01 followers:100..* sort:joined-asc"
 
 	var users []model.User
-	if err := u.Fetch(&users); err != nil {
+	if err := u.Fetch(context.Background(), &users); err != nil {
 		t.Error(err.Error())
 	}
 	if len(users) == 0 {
@@ -50,8 +56,10 @@ func TestStore(t *testing.T) {
 	user := model.User{Login: "memochou1993", Followers: &query.Items{TotalCount: 1}}
 	users := []model.User{user}
 
-	u.UserModel.Store(users)
-	res := database.FindOne(u.UserModel.Name(), bson.D{{"_id", user.ID()}})
+	if _, err := u.UserModel.Store(context.Background(), users); err != nil {
+		t.Fatal(err.Error())
+	}
+	res := database.FindOne(context.Background(), u.UserModel.Name(), bson.D{{"login", user.ID()}})
 	if res.Err() == mongo.ErrNoDocuments {
 		t.Fail()
 	}
@@ -64,15 +72,141 @@ func TestStore(t *testing.T) {
 	test.DropCollection(u.UserModel)
 }
 
+func TestStoreRenamed(t *testing.T) {
+	u := worker.NewUserWorker()
+
+	ctx := context.Background()
+	if _, err := u.UserModel.Store(ctx, []model.User{{Login: "memochou", NodeID: "MDQ6VXNlcjE="}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	repositoryModel := model.NewRepositoryModel()
+	repository := model.Repository{NameWithOwner: "memochou/foo"}
+	repository.Owner.Login = "memochou"
+	if _, err := repositoryModel.Store(ctx, []model.Repository{repository}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, rank := range []model.Rank{
+		{Name: "memochou", Type: app.TypeUser, Field: "followers"},
+		{Name: "memochou/foo", Type: app.TypeRepository, Field: "stargazers"},
+	} {
+		if _, err := database.Collection(u.RankModel.Name()).InsertOne(ctx, rank); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if _, err := u.UserModel.Store(ctx, []model.User{{Login: "memochou1993", NodeID: "MDQ6VXNlcjE="}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	user, err := u.UserModel.FindByLogin(ctx, "memochou")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if user.ID() != "" {
+		t.Error("Expected previous login to be replaced")
+	}
+	if count, err := u.UserModel.Collection().CountDocuments(ctx, bson.D{}); err != nil || count != 1 {
+		t.Errorf("Expected 1 user, Actual: %d", count)
+	}
+	if previous, err := repositoryModel.FindByID(ctx, "memochou/foo"); err != nil || previous.ID() != "" {
+		t.Errorf("Expected repository of previous login to be removed")
+	}
+	repository, err = repositoryModel.FindByID(ctx, "memochou1993/foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if repository.Owner.Login != "memochou1993" {
+		t.Errorf("Expected: %s, Actual: %s", "memochou1993", repository.Owner.Login)
+	}
+	for _, name := range []string{"memochou1993", "memochou1993/foo"} {
+		if err := database.FindOne(ctx, u.RankModel.Name(), bson.D{{"name", name}}).Err(); err != nil {
+			t.Errorf("Expected rank %s to be renamed: %s", name, err.Error())
+		}
+	}
+	user, err = u.UserModel.FindByPreviousLogin(ctx, "memochou")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if user.ID() != "memochou1993" {
+		t.Errorf("Expected: %s, Actual: %s", "memochou1993", user.ID())
+	}
+
+	if err := u.UserModel.MarkGone(ctx, []string{"memochou1993"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	user, err = u.UserModel.FindByLogin(ctx, "memochou1993")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if user.GoneAt == nil {
+		t.Error("Expected user to be marked as gone")
+	}
+
+	test.DropDatabase()
+}
+
+func TestRankByLanguageAndLocation(t *testing.T) {
+	u := worker.NewUserWorker()
+	viper.Set("RANK_LANGUAGES", "Go;JavaScript")
+	viper.Set("RANK_LOCATIONS", "Taiwan;Taipei, Taiwan;Germany")
+	defer viper.Reset()
+
+	ctx := context.Background()
+	user := model.User{Login: "memochou1993", Location: "Taipei, Taiwan"}
+	if _, err := u.UserModel.Store(ctx, []model.User{user}); err != nil {
+		t.Fatal(err.Error())
+	}
+	var repositories []model.Repository
+	for i, language := range []string{"Go", "Go", "PHP"} {
+		repository := model.Repository{NameWithOwner: fmt.Sprintf("memochou1993/%d", i), Stargazers: &query.Items{TotalCount: i + 1}}
+		repository.PrimaryLanguage.Name = language
+		repositories = append(repositories, repository)
+	}
+	if err := u.UserModel.UpdateRepositories(ctx, user, repositories); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var p *pipeline.Pipeline
+	for _, rank := range pipeline.RankUser() {
+		if rank.Field == "repositories.stargazers" && len(rank.Slices) > 0 {
+			p = rank
+		}
+	}
+	if p == nil {
+		t.Fatal("Expected pipeline")
+	}
+	cursor, err := database.Aggregate(ctx, u.UserModel.Name(), *p.Pipeline)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var recs []struct {
+		ID         string `bson:"_id"`
+		TotalCount int    `bson:"total_count"`
+		Language   string `bson:"language"`
+		Location   string `bson:"location"`
+	}
+	if err := cursor.All(ctx, &recs); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 records, Actual: %d", len(recs))
+	}
+	for i, location := range []string{"Taipei, Taiwan", "Taiwan"} {
+		if rec := recs[i]; rec.ID != user.ID() || rec.Language != "Go" || rec.Location != location || rec.TotalCount != 3 {
+			t.Errorf("Expected: %s Go %s 3, Actual: %+v", user.ID(), location, rec)
+		}
+	}
+
+	test.DropCollection(u.UserModel)
+}
+
 func TestFetchGists(t *testing.T) {
 	u := worker.NewUserWorker()
 
 	u.GistQuery = query.OwnerGists()
-	u.GistQuery.Type = app.TypeUser
-	u.GistQuery.OwnerArguments.Login = strconv.Quote("memochou1993")
+	u.GistQuery.OwnerArguments.Login = "memochou1993"
 
 	var gists []query.Gist
-	if err := u.FetchGists(&gists); err != nil {
+	if err := u.FetchGists(context.Background(), &gists); err != nil {
 		t.Error(err.Error())
 	}
 	if len(gists) == 0 {
@@ -86,11 +220,10 @@ func TestFetchRepositories(t *testing.T) {
 	u := worker.NewUserWorker()
 
 	u.RepositoryQuery = query.OwnerRepositories()
-	u.RepositoryQuery.Type = app.TypeUser
-	u.RepositoryQuery.OwnerArguments.Login = strconv.Quote("memochou1993")
+	u.RepositoryQuery.OwnerArguments.Login = "memochou1993"
 
 	var repositories []model.Repository
-	if err := u.FetchRepositories(&repositories); err != nil {
+	if err := u.FetchRepositories(context.Background(), &repositories); err != nil {
 		t.Error(err.Error())
 	}
 	if len(repositories) == 0 {
@@ -100,6 +233,25 @@ func TestFetchRepositories(t *testing.T) {
 	test.DropCollection(u.UserModel)
 }
 
+func TestFetchContributedRepositories(t *testing.T) {
+	u := worker.NewUserWorker()
+
+	u.ContributedRepositoryQuery = query.OwnerContributedRepositories()
+	u.ContributedRepositoryQuery.OwnerArguments.Login = "memochou1993"
+
+	var repositories []model.Repository
+	if err := u.FetchContributedRepositories(context.Background(), &repositories); err != nil {
+		t.Error(err.Error())
+	}
+	for _, repository := range repositories {
+		if repository.Owner.Login == "memochou1993" {
+			t.Errorf("Expected owned repository %s to be excluded", repository.ID())
+		}
+	}
+
+	test.DropCollection(u.UserModel)
+}
+
 func tearDown() {
 	test.DropDatabase()
 }
@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/memochou1993/gh-rankings/app"
+	"github.com/memochou1993/gh-rankings/app/model"
+	"github.com/memochou1993/gh-rankings/app/query"
+	"github.com/memochou1993/gh-rankings/app/worker"
+	"github.com/memochou1993/gh-rankings/database"
+	"github.com/memochou1993/gh-rankings/test"
+	"github.com/memochou1993/gh-rankings/util"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	setUp()
+	code := m.Run()
+	tearDown()
+	os.Exit(code)
+}
+
+func setUp() {
+	util.LoadEnv()
+	viper.Set("RETRY_ATTEMPTS", 1)
+	if err := database.Connect(); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func TestCollectAndRank(t *testing.T) {
+	path, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(path)
+
+	since := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	q := query.Repositories()
+	q.SearchArguments.SetQuery(query.SearchRepositories(since, since.AddDate(0, 0, 7)))
+	b := []byte(`{"data":{"search":{"repositoryCount":2,"edges":[
+		{"cursor":"Y3Vyc29yOjE=","node":{"createdAt":"2020-01-02T00:00:00Z","name":"foo","nameWithOwner":"memochou1993/foo","owner":{"login":"memochou1993"},"primaryLanguage":{"name":"Go"},"stargazers":{"totalCount":100}}},
+		{"cursor":"Y3Vyc29yOjI=","node":{"createdAt":"2020-01-03T00:00:00Z","name":"bar","nameWithOwner":"memochou1993/bar","owner":{"login":"memochou1993"},"primaryLanguage":{"name":"Go"},"stargazers":{"totalCount":200}}}
+	],"pageInfo":{"endCursor":"Y3Vyc29yOjI=","hasNextPage":false}}}}`)
+	if err := ioutil.WriteFile(filepath.Join(path, app.FixtureName(fmt.Sprint(*q))), b, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r := worker.RepositoryWorker
+	r.Source = app.NewFixtureSource(path)
+	r.Since = since
+	r.Clock = func() time.Time {
+		return since.AddDate(0, 0, 3)
+	}
+	ctx := context.Background()
+	if err := worker.Execute(ctx, app.TypeRepository, worker.CommandCollect); err != nil {
+		t.Fatal(err.Error())
+	}
+	if r.Timestamp.IsZero() {
+		t.Fatal("Expected ranks to be published")
+	}
+
+	filter := bson.D{
+		{"type", app.TypeRepository},
+		{"field", "stargazers"},
+		{"language", ""},
+		{"location", ""},
+		{"created_at", r.Timestamp},
+	}
+	var ranks []model.Rank
+	if err := model.NewRankModel().Model.List(ctx, filter, &ranks); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(ranks) != 2 {
+		t.Fatalf("Expected 2 ranks, Actual: %d", len(ranks))
+	}
+	for _, rank := range ranks {
+		expected := map[string]int{"memochou1993/bar": 1, "memochou1993/foo": 2}[rank.Name]
+		if rank.Rank != expected || rank.RankCount != 2 {
+			t.Errorf("Name: %s, Expected: %d, Actual: %d", rank.Name, expected, rank.Rank)
+		}
+	}
+
+	test.DropDatabase()
+}
+
+func TestUpdateResourcesSkipsForbiddenOwner(t *testing.T) {
+	path, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(path)
+
+	ctx := context.Background()
+	o := worker.OrganizationWorker
+	organizations := []model.Organization{{Login: "foo"}, {Login: "bar"}}
+	if _, err := o.OrganizationModel.Store(ctx, organizations); err != nil {
+		t.Fatal(err.Error())
+	}
+	q := query.OwnerResources(app.TypeOrganization, []string{"foo", "bar"})
+	b := []byte(`{"data":{"o0":null,"o1":{"login":"bar","repositories":{"edges":[
+		{"cursor":"Y3Vyc29yOjE=","node":{"name":"baz","nameWithOwner":"bar/baz","owner":{"login":"bar"},"stargazers":{"totalCount":1}}}
+	],"pageInfo":{"hasNextPage":false}}}},"errors":[
+		{"type":"FORBIDDEN","path":["o0"],"message":"Resource protected by organization SAML enforcement."}
+	]}`)
+	if err := ioutil.WriteFile(filepath.Join(path, app.FixtureName(fmt.Sprint(q))), b, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	o.Source = app.NewFixtureSource(path)
+	defer func() {
+		o.Source = nil
+	}()
+	if err := o.UpdateResources(ctx, organizations); err != nil {
+		t.Fatal(err.Error())
+	}
+	foo, err := o.OrganizationModel.FindByLogin(ctx, "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if foo.GoneAt != nil {
+		t.Error("Expected forbidden organization not to be marked as gone")
+	}
+	bar, err := o.OrganizationModel.FindByLogin(ctx, "bar")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(bar.Repositories) != 1 {
+		t.Errorf("Expected: %d, Actual: %d", 1, len(bar.Repositories))
+	}
+
+	test.DropDatabase()
+}
+
+func TestRankKeepsStagingRanksOfRunningRuns(t *testing.T) {
+	ctx := context.Background()
+	runModel := model.NewWorkerRunModel()
+	rankModel := model.NewRankModel()
+	running := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	failed := running.Add(time.Minute)
+	for _, run := range []model.WorkerRun{
+		{ID: primitive.NewObjectID(), Worker: app.TypeRepository, Command: worker.CommandRank, Status: model.RunStatusRunning, StartedAt: running, Staging: running},
+		{ID: primitive.NewObjectID(), Worker: app.TypeRepository, Command: worker.CommandRank, Status: model.RunStatusFailed, StartedAt: failed, Staging: failed},
+	} {
+		if err := runModel.Store(ctx, run); err != nil {
+			t.Fatal(err.Error())
+		}
+		rank := model.Rank{Name: "memochou1993/foo", Type: app.TypeRepository, Field: "stargazers", CreatedAt: run.Staging}
+		if _, err := rankModel.Collection().InsertOne(ctx, rank); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if err := worker.Execute(ctx, app.TypeRepository, worker.CommandRank); err != nil {
+		t.Fatal(err.Error())
+	}
+	for createdAt, expected := range map[time.Time]int64{running: 1, failed: 0} {
+		count, err := rankModel.Collection().CountDocuments(ctx, bson.D{{"created_at", createdAt}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if count != expected {
+			t.Errorf("Created At: %s, Expected: %d, Actual: %d", createdAt, expected, count)
+		}
+	}
+
+	test.DropDatabase()
+}
+
+func TestRerankField(t *testing.T) {
+	ctx := context.Background()
+	repositoryModel := model.NewRepositoryModel()
+	rankModel := model.NewRankModel()
+	runModel := model.NewWorkerRunModel()
+	store := func(stargazers int) {
+		repository := model.Repository{NameWithOwner: "memochou1993/foo", Name: "foo"}
+		repository.Owner.Login = "memochou1993"
+		repository.Forks = &query.Items{TotalCount: 1}
+		repository.Stargazers = &query.Items{TotalCount: stargazers}
+		repository.Watchers = &query.Items{TotalCount: 1}
+		if _, err := repositoryModel.Store(ctx, []model.Repository{repository}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	count := func(field string, createdAt time.Time) int64 {
+		filter := bson.D{{"type", app.TypeRepository}, {"field", field}, {"created_at", createdAt}}
+		n, err := rankModel.Collection().CountDocuments(ctx, filter)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return n
+	}
+
+	store(1)
+	if err := worker.Execute(ctx, app.TypeRepository, worker.CommandRank); err != nil {
+		t.Fatal(err.Error())
+	}
+	published, err := runModel.Snapshot(ctx, app.TypeRepository)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := worker.Rerank(ctx, app.TypeRepository, "unknown"); err != worker.ErrUnknownField {
+		t.Errorf("Expected: %v, Actual: %v", worker.ErrUnknownField, err)
+	}
+	store(2)
+	if err := worker.Rerank(ctx, app.TypeRepository, "stargazers"); err != nil {
+		t.Fatal(err.Error())
+	}
+	run, err := runModel.Snapshot(ctx, app.TypeRepository)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !run.Snapshot.After(published.Snapshot) {
+		t.Fatalf("Expected a new snapshot after %s, Actual: %s", published.Snapshot, run.Snapshot)
+	}
+	for field, expected := range map[string]time.Time{"forks": published.Snapshot, "stargazers": run.Snapshot, "watchers": published.Snapshot} {
+		if actual := run.CreatedAt(field); !actual.Equal(expected) {
+			t.Errorf("Field: %s, Expected: %s, Actual: %s", field, expected, actual)
+		}
+	}
+	if count("stargazers", published.Snapshot) == 0 {
+		t.Error("Expected previous stargazers ranks to be kept")
+	}
+	if n := count("forks", run.Snapshot); n != 0 {
+		t.Errorf("Expected forks ranks not to be copied, Actual: %d", n)
+	}
+
+	rank := model.Rank{}
+	filter := bson.D{{"type", app.TypeRepository}, {"field", "stargazers"}, {"language", ""}, {"location", ""}, {"created_at", run.Snapshot}}
+	if err := rankModel.Collection().FindOne(ctx, filter).Decode(&rank); err != nil {
+		t.Fatal(err.Error())
+	}
+	if rank.ItemCount != 2 || rank.ItemCountChange != 1 {
+		t.Errorf("Expected item count 2 changed by 1, Actual: %d changed by %d", rank.ItemCount, rank.ItemCountChange)
+	}
+
+	test.DropDatabase()
+}
+
+func tearDown() {
+	test.DropDatabase()
+}